@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// Return a JSON archive of everything that we store about the current user.
+// Secrets such as password hashes, token hashes and TOTP secrets are never
+// included.
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.getSessions(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	organizations, err := app.models.Organizations.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	reviews, err := app.models.Reviews.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watchlist, err := app.models.Watchlist.GetAllForExport(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watched, err := app.models.Watched.GetAllForExport(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	totpEnabled, err := app.models.TOTP.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A pending email change is stored against the user, so include it too.
+	var pendingEmail *string
+
+	email, err := app.models.EmailChanges.GetForUser(user.ID)
+	switch {
+	case err == nil:
+		pendingEmail = &email
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	export := envelope{
+		"exported_at":          time.Now().UTC(),
+		"user":                 user,
+		"roles":                roles,
+		"permissions":          permissions,
+		"sessions":             sessions,
+		"api_keys":             apiKeys,
+		"identities":           identities,
+		"organizations":        organizations,
+		"reviews":              reviews,
+		"watchlist":            watchlist,
+		"watched":              watched,
+		"two_factor_enabled":   totpEnabled,
+		"pending_email_change": pendingEmail,
+	}
+
+	// Set a Content-Disposition header so that browsers save the response as a
+	// file, rather than displaying it.
+	headers := make(http.Header)
+	headers.Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="greenlight-export-%d.json"`, user.ID))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Schedule the current user's account for deletion. The account isn't deleted
+// straight away; instead all of the user's sessions and API keys are revoked,
+// logging in is blocked, and the account is purged once the grace period has
+// passed. Until then, the user can cancel the deletion using the token that we
+// email to them.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	mismatch := func() { app.invalidCredentialsResponse(w, r) }
+
+	if !app.matchCurrentPassword(w, r, user, input.Password, mismatch) {
+		return
+	}
+
+	// Don't let the only admin of an organization delete their account, as
+	// that would leave the organization with nobody who can manage it. They
+	// need to make someone else an admin, or delete the organization, first.
+	count, err := app.models.Organizations.CountSoleAdminOrganizations(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if count > 0 {
+		app.soleOrganizationAdminResponse(w, r)
+		return
+	}
+
+	purgeAfter := time.Now().Add(app.config.accounts.deletionGracePeriod)
+
+	err = app.models.AccountDeletions.Schedule(user.ID, purgeAfter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The cancellation token lasts for the whole of the grace period.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAccountDeletion, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID,
+		app.config.accounts.deletionGracePeriod, data.ScopeAccountDeletion)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"cancellationToken": token.Plaintext,
+			"purgeAfter":        purgeAfter.UTC().Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(user.Email, "account_deletion.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": fmt.Sprintf("your account will be deleted after %s; an email will be sent to you containing instructions to cancel the deletion",
+		purgeAfter.UTC().Format(time.RFC3339))}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Cancel a scheduled account deletion using the token from the cancellation
+// email. The user can then log in again as normal.
+func (app *application) cancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeAccountDeletion,
+		input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired cancellation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the deletion has already been cancelled there is nothing to do, so we
+	// just carry on and clean up the token.
+	err = app.models.AccountDeletions.Cancel(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAccountDeletion, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "account deletion successfully cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkAccountDeletion() sends an error response and returns false if the
+// user's account is scheduled for deletion. It is used to block logins during
+// the grace period.
+func (app *application) checkAccountDeletion(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	purgeAfter, err := app.models.AccountDeletions.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if purgeAfter != nil {
+		app.accountPendingDeletionResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// purgeDeletedAccounts() runs in a background goroutine, periodically deleting
+// any accounts whose deletion grace period has passed.
+func (app *application) purgeDeletedAccounts() {
+	ticker := time.NewTicker(app.config.accounts.purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := app.models.AccountDeletions.Purge()
+		if err != nil {
+			app.logger.Error(err.Error())
+			continue
+		}
+
+		if count > 0 {
+			app.logger.Info("purged deleted accounts", "count", count)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The handlers in this file make up the /v1/admin/users API, which lets users
+// with the "admin:users" permission manage other users' accounts.
+
+// List all users, with optional filtering by name and email address, and the
+// same pagination and sorting parameters as GET /v1/movies.
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name  string
+		Email string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{
+		"id", "name", "email", "created_at",
+		"-id", "-name", "-email", "-created_at",
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Name, input.Email,
+		input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show a specific user, along with their roles and effective permissions.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles, permissions, err := app.getUserAccess(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"user": user, "roles": roles, "permissions": permissions}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show a specific user's roles and effective permissions.
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles, permissions, err := app.getUserAccess(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Grant permission codes directly to a specific user.
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that every code actually exists, otherwise AddForUser() would
+	// silently skip it.
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range input.Permissions {
+		if !all.Include(code) {
+			v.AddError("permissions", fmt.Sprintf("%q is not a valid permission", code))
+			break
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Revoke the user's signed access tokens, so that the change takes effect
+	// straight away rather than when they next refresh their token.
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserAccess(w, r, user.ID)
+}
+
+// Revoke a permission code which was granted directly to a specific user.
+// Permissions which come from one of the user's roles can only be taken away
+// by revoking the role.
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	err := app.models.Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Revoke the user's signed access tokens, so that the change takes effect
+	// straight away rather than when they next refresh their token.
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserAccess(w, r, user.ID)
+}
+
+// Give a specific user one or more roles.
+func (app *application) grantUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Roles) >= 1, "roles", "must contain at least 1 role")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	for _, name := range input.Roles {
+		_, err := app.models.Roles.Get(name)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("roles", fmt.Sprintf("%q is not a valid role", name))
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, input.Roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Revoke the user's signed access tokens, so that the change takes effect
+	// straight away rather than when they next refresh their token.
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserAccess(w, r, user.ID)
+}
+
+// Take a role away from a specific user.
+func (app *application) revokeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	role := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	err := app.models.Roles.RemoveForUser(user.ID, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Revoke the user's signed access tokens, so that the change takes effect
+	// straight away rather than when they next refresh their token.
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserAccess(w, r, user.ID)
+}
+
+// Force-activate or deactivate a specific user's account. Deactivated users
+// can still log in, but can't use any endpoint which requires an activated
+// account.
+func (app *application) updateUserActivationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Activated != nil, "activated", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Activated = *input.Activated
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the account was activated, any outstanding activation tokens are no
+	// longer needed.
+	if user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke all of a specific user's tokens, in every scope, along with their API
+// keys. This logs the user out everywhere.
+func (app *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Tokens.DeleteAllScopesForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "all tokens and API keys successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUser() looks up the user identified by the "id" URL parameter. If the
+// user can't be found, an error response is sent and ok is false.
+func (app *application) readUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// getUserAccess() returns a user's roles and effective permissions.
+func (app *application) getUserAccess(userID int64) ([]string, data.Permissions, error) {
+	roles, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return roles, permissions, nil
+}
+
+// writeUserAccess() sends a user's roles and effective permissions in the
+// response, after they have been changed.
+func (app *application) writeUserAccess(w http.ResponseWriter, r *http.Request, userID int64) {
+	roles, permissions, err := app.getUserAccess(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
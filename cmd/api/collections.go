@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// List the collections in the active organization's catalog, optionally
+// filtered by name.
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "-id", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collections, metadata, err := app.models.Collections.GetAll(
+		app.contextGetMembership(r).OrganizationID,
+		input.Name,
+		input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create a new collection in the active organization's catalog. If movie_ids
+// are given, then those movies are added to the collection in that order.
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		MovieIDs    []int64 `json:"movie_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		OrganizationID: app.contextGetMembership(r).OrganizationID,
+		Name:           input.Name,
+		Description:    input.Description,
+	}
+
+	v := validator.New()
+
+	data.ValidateCollection(v, collection)
+
+	if input.MovieIDs != nil {
+		data.ValidateCollectionMovies(v, input.MovieIDs)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(collection, input.MovieIDs)
+	if err != nil {
+		app.collectionErrorResponse(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show a collection, along with a page of its movies in order.
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "position")
+	input.Filters.SortSafeList = []string{"position", "-position"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Collections.GetMovies(collection.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"collection": collection, "movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update a collection's details. If movie_ids are given, then they replace
+// the collection's movies, in that order.
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		MovieIDs    []int64 `json:"movie_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		collection.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+
+	v := validator.New()
+
+	data.ValidateCollection(v, collection)
+
+	if input.MovieIDs != nil {
+		data.ValidateCollectionMovies(v, input.MovieIDs)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(collection, input.MovieIDs)
+	if err != nil {
+		app.collectionErrorResponse(w, r, v, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete a collection. The movies in it are not deleted.
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Collections.Delete(id, app.contextGetMembership(r).OrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readCollection() helper fetches the collection named by the "id" URL
+// parameter from the active organization's catalog. If there is no such
+// collection, or anything else goes wrong, an error response is sent and ok is
+// false.
+func (app *application) readCollection(w http.ResponseWriter, r *http.Request) (*data.Collection, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	collection, err := app.models.Collections.Get(id, app.contextGetMembership(r).OrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return collection, true
+}
+
+// The collectionErrorResponse() helper sends the response for an error from
+// inserting or updating a collection, including the errors for movie_ids which
+// can't be added to it.
+func (app *application) collectionErrorResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	v *validator.Validator,
+	err error,
+) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("movie_ids", "must only contain movies in this organization's catalog")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrMovieInCollection):
+		v.AddError("movie_ids", "must not contain movies which belong to another collection")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/jwt"
 )
 
 // Define a custom contextKey type, with the underlying type string.
@@ -15,6 +16,22 @@ type contextKey string
 // setting user information in the request context.
 const userContextKey = contextKey("user")
 
+// The tokenContextKey constant is used for storing the plaintext
+// authentication token that the request was authenticated with.
+const tokenContextKey = contextKey("token")
+
+// The permissionsContextKey constant is used for storing the permissions that
+// the request's credential is restricted to, if any.
+const permissionsContextKey = contextKey("permissions")
+
+// The claimsContextKey constant is used for storing the claims of the signed
+// access token that the request was authenticated with, if any.
+const claimsContextKey = contextKey("claims")
+
+// The membershipContextKey constant is used for storing the user's membership
+// of the organization that the request is operating within.
+const membershipContextKey = contextKey("membership")
+
 // The contextSetUser() method returns a new copy of the request with the
 // provided User struct added to the context. Note that we use our
 // useContextKey constant as the key.
@@ -38,3 +55,79 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// The contextSetToken() method returns a new copy of the request with the
+// provided plaintext authentication token added to the context.
+func (app *application) contextSetToken(r *http.Request,
+	token string,
+) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() method retrieves the plaintext authentication token
+// from the request context. If the request wasn't authenticated with an
+// authentication token (for example, it used an API key instead) then the
+// empty string is returned.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// The contextSetPermissions() method returns a new copy of the request with a
+// set of permissions that the request's credential is restricted to. The
+// requirePermission() middleware will only allow permissions which are in
+// both this set and the user's own permissions.
+func (app *application) contextSetPermissions(r *http.Request,
+	permissions data.Permissions,
+) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The contextGetPermissions() method retrieves the permissions that the
+// request's credential is restricted to. The second return value is false if
+// the credential isn't restricted.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// The contextSetClaims() method returns a new copy of the request with the
+// claims from a signed access token added to the context.
+func (app *application) contextSetClaims(r *http.Request,
+	claims *jwt.Claims,
+) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// The contextGetClaims() method retrieves the claims of the signed access
+// token that the request was authenticated with. The second return value is
+// false if the request wasn't authenticated with a signed access token.
+func (app *application) contextGetClaims(r *http.Request) (*jwt.Claims, bool) {
+	claims, ok := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims, ok
+}
+
+// The contextSetMembership() method returns a new copy of the request with the
+// user's membership of the active organization added to the context.
+func (app *application) contextSetMembership(r *http.Request,
+	membership *data.Membership,
+) *http.Request {
+	ctx := context.WithValue(r.Context(), membershipContextKey, membership)
+	return r.WithContext(ctx)
+}
+
+// The contextGetMembership() method retrieves the user's membership of the
+// active organization from the request context. Like contextGetUser(), we only
+// use this in handlers which are wrapped with the requireOrganization()
+// middleware, so it panics if there is no membership in the context.
+func (app *application) contextGetMembership(r *http.Request) *data.Membership {
+	membership, ok := r.Context().Value(membershipContextKey).(*data.Membership)
+	if !ok {
+		panic("missing membership value in request context")
+	}
+
+	return membership
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// List the credits on a movie.
+func (app *application) listMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovie(w, r)
+	if !ok {
+		return
+	}
+
+	credits, err := app.models.Credits.GetAllForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Credit a person on a movie. Both must be in the active organization's
+// catalog.
+func (app *application) createCreditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID   int64  `json:"movie_id"`
+		PersonID  int64  `json:"person_id"`
+		Role      string `json:"role"`
+		Character string `json:"character"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:   input.MovieID,
+		PersonID:  input.PersonID,
+		Role:      input.Role,
+		Character: input.Character,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	organizationID := app.contextGetMembership(r).OrganizationID
+
+	_, err = app.models.Movies.Get(credit.MovieID, organizationID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("movie_id", "no matching movie found")
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	person, err := app.models.People.Get(credit.PersonID, organizationID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("person_id", "no matching person found")
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	credit.Name = person.Name
+
+	err = app.models.Credits.Insert(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("person_id", "this person already has this credit on the movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/credits/%d", credit.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"credit": credit}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCreditHandler(w http.ResponseWriter, r *http.Request) {
+	credit, ok := app.readCredit(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"credit": credit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Change the role or character of a credit. To move a credit to a different
+// movie or person, delete it and create a new one.
+func (app *application) updateCreditHandler(w http.ResponseWriter, r *http.Request) {
+	credit, ok := app.readCredit(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Role      *string `json:"role"`
+		Character *string `json:"character"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role != nil {
+		credit.Role = *input.Role
+	}
+
+	if input.Character != nil {
+		credit.Character = *input.Character
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Credits.Update(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("role", "this person already has this credit on the movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"credit": credit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCreditHandler(w http.ResponseWriter, r *http.Request) {
+	credit, ok := app.readCredit(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Credits.Delete(credit.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "credit successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readCredit() helper fetches the credit named by the "id" URL parameter,
+// as long as it is on a movie in the active organization's catalog.
+func (app *application) readCredit(w http.ResponseWriter, r *http.Request) (*data.Credit, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	credit, err := app.models.Credits.Get(id, app.contextGetMembership(r).OrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return credit, true
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // The logError() method is a generic helper for logging an error message along
@@ -141,3 +145,89 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// The restrictedCredentialResponse() method is used when a request made with a
+// restricted credential, like an API key or a scoped token, tries to access a
+// resource which isn't covered by any permission.
+func (app *application) restrictedCredentialResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key or scoped token"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The accountLockedResponse() method is used when a user account has been
+// temporarily locked because of too many failed login attempts. We include a
+// Retry-After header so that well-behaved clients know how long to wait.
+func (app *application) accountLockedResponse(w http.ResponseWriter,
+	r *http.Request,
+	lockedUntil time.Time,
+) {
+	retryAfter := math.Ceil(time.Until(lockedUntil).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(retryAfter), 1)))
+
+	message := "this account has been temporarily locked due to too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// The accountPendingDeletionResponse() method is used to refuse logins to an
+// account that is scheduled for deletion.
+func (app *application) accountPendingDeletionResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this account is scheduled for deletion; use the link in the cancellation email to restore it"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The oidcFailedResponse() method is used when a login with the external
+// identity provider can't be completed.
+func (app *application) oidcFailedResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The registrationClosedResponse() method is used when someone tries to
+// register an account while registration is closed.
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "registration of new accounts is closed"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The notOrganizationMemberResponse() method is used when a user tries to work
+// within an organization that they aren't a member of.
+func (app *application) notOrganizationMemberResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you are not a member of this organization"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The organizationRoleRequiredResponse() method is used when a user's role
+// within an organization doesn't allow the action that they are trying to
+// take.
+func (app *application) organizationRoleRequiredResponse(w http.ResponseWriter, r *http.Request, role string) {
+	message := fmt.Sprintf("you must have the %s role in this organization to access this resource", role)
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The lastOrganizationAdminResponse() method is used when a change would leave
+// an organization without any admins.
+func (app *application) lastOrganizationAdminResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an organization must have at least one admin"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// The soleOrganizationAdminResponse() method is used when a user who is the
+// only admin of an organization tries to delete their account.
+func (app *application) soleOrganizationAdminResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you are the only admin of an organization; make another member an admin or delete the organization before deleting your account"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// The notReviewAuthorResponse() method is used when a user tries to change
+// someone else's review.
+func (app *application) notReviewAuthorResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you can only change your own reviews"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The unsupportedMediaTypeResponse() method is used when a request body isn't
+// in one of the formats that an endpoint accepts.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the Content-Type header must be one of %s",
+		strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
@@ -22,11 +22,17 @@ type envelope map[string]any
 // convert it to an integer and return it. If the operation isn't successful,
 // return 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// The readInt64Param() helper does the same for any named URL parameter, for
+// routes which have more than one ID in them.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -102,6 +108,29 @@ func (app *application) readInt(
 	return i
 }
 
+// The readBool() helper reads a boolean value from the query string, in the
+// same way that readInt() reads an integer.
+func (app *application) readBool(
+	qs url.Values,
+	key string,
+	defaultValue bool,
+	v *validator.Validator,
+) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
 func (app *application) readJSON(
 	w http.ResponseWriter,
 	r *http.Request,
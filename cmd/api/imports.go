@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// The media types which movies can be imported from.
+const (
+	importCSV    = "text/csv"
+	importNDJSON = "application/x-ndjson"
+)
+
+// The columns which a CSV import must have, in any order.
+var importColumns = []string{"title", "year", "runtime", "genres"}
+
+// The most invalid rows which are listed in an import's response. Any more are
+// only counted, so that a large file full of bad rows can't produce an
+// enormous response.
+const maxImportFailures = 1000
+
+// An importFailure describes a row which couldn't be imported. Line is the
+// line of the request body that the row starts on, counting from 1.
+type importFailure struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+// An importReport is the response to a movie import. Rows is the number of
+// rows read, Valid is the number of them which passed validation, and
+// Imported is the number which were inserted, which is always 0 for a dry run.
+// Failed is the number of invalid rows, of which at most maxImportFailures are
+// listed in Failures.
+type importReport struct {
+	DryRun   bool            `json:"dry_run"`
+	Rows     int             `json:"rows"`
+	Valid    int             `json:"valid"`
+	Imported int             `json:"imported"`
+	Failed   int             `json:"failed"`
+	Failures []importFailure `json:"failures"`
+}
+
+// A movieReader reads the movies in an import one row at a time. Next()
+// returns the line that the row starts on and the movie in it, adding any
+// problems with the row to the validator. If the row couldn't be parsed at
+// all, then the movie is nil. At the end of the body Next() returns io.EOF;
+// any other error means that the rest of the body can't be read.
+type movieReader interface {
+	Next(v *validator.Validator) (line int, movie *data.Movie, err error)
+}
+
+// Import many movies into the active organization's catalog at once, from a
+// CSV or newline-delimited JSON request body. The body is read one row at a
+// time, and valid movies are inserted in batches, each batch in its own
+// transaction. Invalid rows don't stop the import; instead the response lists
+// the line that each of them starts on and what was wrong with it. If the body
+// can't be read to the end or a batch can't be inserted, then any batches
+// which were already inserted are kept, and the error response includes the
+// report so far so that the client knows how many were. With dry_run=true the
+// rows are validated but nothing is inserted.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// An import can take much longer to send than the server's read and write
+	// timeouts allow, so extend the deadlines for this request.
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.config.imports.timeout)
+
+	err := rc.SetReadDeadline(deadline)
+	if err == nil {
+		err = rc.SetWriteDeadline(deadline)
+	}
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	var movies movieReader
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case importCSV:
+		movies, err = newCSVMovieReader(body)
+		if err != nil {
+			app.badRequestResponse(w, r, importReadError(err))
+			return
+		}
+	case importNDJSON:
+		movies = newNDJSONMovieReader(body)
+	default:
+		app.unsupportedMediaTypeResponse(w, r, importCSV, importNDJSON)
+		return
+	}
+
+	organizationID := app.contextGetMembership(r).OrganizationID
+
+	report := importReport{DryRun: dryRun, Failures: []importFailure{}}
+	batch := make([]*data.Movie, 0, app.config.imports.batchSize)
+
+	insertBatch := func() error {
+		err := app.models.Movies.InsertBatch(batch)
+		if err != nil {
+			return err
+		}
+
+		report.Imported += len(batch)
+		batch = batch[:0]
+
+		return nil
+	}
+
+	for {
+		v := validator.New()
+
+		line, movie, err := movies.Next(v)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			app.importErrorResponse(w, r, http.StatusBadRequest, importReadError(err).Error(), report)
+			return
+		}
+
+		report.Rows++
+
+		if movie != nil {
+			movie.OrganizationID = organizationID
+			data.ValidateMovie(v, movie)
+		}
+
+		if !v.Valid() {
+			report.Failed++
+			if len(report.Failures) < maxImportFailures {
+				report.Failures = append(report.Failures, importFailure{Line: line, Errors: v.Errors})
+			}
+			continue
+		}
+
+		report.Valid++
+
+		if dryRun {
+			continue
+		}
+
+		batch = append(batch, movie)
+
+		if len(batch) >= app.config.imports.batchSize {
+			err = insertBatch()
+			if err != nil {
+				app.importServerErrorResponse(w, r, err, report)
+				return
+			}
+		}
+	}
+
+	if len(batch) > 0 {
+		err = insertBatch()
+		if err != nil {
+			app.importServerErrorResponse(w, r, err, report)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The importErrorResponse() method sends an error response for an import which
+// stopped part of the way through, along with the report so far.
+func (app *application) importErrorResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	message string,
+	report importReport,
+) {
+	env := envelope{"error": message, "import": report}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// The importServerErrorResponse() method is used when a batch of movies can't
+// be inserted. Like serverErrorResponse() it logs the error and sends a
+// generic message, but the report is included so that the client can tell how
+// many of the movies were imported before the failure.
+func (app *application) importServerErrorResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	err error,
+	report importReport,
+) {
+	app.logError(r, err)
+
+	message := "the server encountered a problem and could not finish the import"
+	app.importErrorResponse(w, r, http.StatusInternalServerError, message, report)
+}
+
+// The importReadError() helper turns an error from reading an import's body
+// into a message for the client.
+func importReadError(err error) error {
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	default:
+		return err
+	}
+}
+
+// A csvMovieReader reads movies from CSV. The first record is a header naming
+// the columns, and the genres column holds a comma-separated list of genres.
+// Runtimes can be given either as a number of minutes or in the same
+// "<runtime> mins" format as the JSON API.
+type csvMovieReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVMovieReader(body io.Reader) (*csvMovieReader, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		// Spreadsheet programs often start CSV files with a byte order mark,
+		// so ignore one if it's there.
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("body contains unknown column %q", name)
+		}
+
+		if _, exists := columns[name]; exists {
+			return nil, fmt.Errorf("body contains duplicate column %q", name)
+		}
+
+		columns[name] = i
+	}
+
+	for _, name := range importColumns {
+		if _, exists := columns[name]; !exists {
+			return nil, fmt.Errorf("body is missing the %q column", name)
+		}
+	}
+
+	return &csvMovieReader{reader: reader, columns: columns}, nil
+}
+
+func (cr *csvMovieReader) Next(v *validator.Validator) (int, *data.Movie, error) {
+	record, err := cr.reader.Read()
+	if err != nil {
+		// A csv.ParseError only affects one record, so report it against the
+		// row and carry on with the next one.
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			v.AddError("row", parseError.Err.Error())
+			return parseError.StartLine, nil, nil
+		}
+		return 0, nil, err
+	}
+
+	line, _ := cr.reader.FieldPos(0)
+
+	movie := &data.Movie{
+		Title: record[cr.columns["title"]],
+	}
+
+	if s := strings.TrimSpace(record[cr.columns["year"]]); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			v.AddError("year", "must be an integer value")
+		}
+		movie.Year = int32(year)
+	}
+
+	if s := strings.TrimSpace(record[cr.columns["runtime"]]); s != "" {
+		runtime, err := strconv.ParseInt(strings.TrimSuffix(s, " mins"), 10, 32)
+		if err != nil {
+			v.AddError("runtime", "must be a number of minutes")
+		}
+		movie.Runtime = data.Runtime(runtime)
+	}
+
+	if s := strings.TrimSpace(record[cr.columns["genres"]]); s != "" {
+		for genre := range strings.SplitSeq(s, ",") {
+			movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+		}
+	}
+
+	return line, movie, nil
+}
+
+// An ndjsonMovieReader reads movies from newline-delimited JSON, where each
+// line holds one movie as a JSON object in the same format that the create
+// movie endpoint accepts. Blank lines are skipped.
+type ndjsonMovieReader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+func newNDJSONMovieReader(body io.Reader) *ndjsonMovieReader {
+	return &ndjsonMovieReader{reader: bufio.NewReader(body)}
+}
+
+func (nr *ndjsonMovieReader) Next(v *validator.Validator) (int, *data.Movie, error) {
+	for {
+		b, err := nr.reader.ReadBytes('\n')
+		if err != nil && (len(b) == 0 || !errors.Is(err, io.EOF)) {
+			return 0, nil, err
+		}
+
+		nr.line++
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(&input)
+		if err != nil {
+			addImportJSONError(v, err)
+			return nr.line, nil, nil
+		}
+
+		if !errors.Is(dec.Decode(&struct{}{}), io.EOF) {
+			v.AddError("row", "must only contain a single JSON value")
+			return nr.line, nil, nil
+		}
+
+		movie := &data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}
+
+		return nr.line, movie, nil
+	}
+}
+
+// The addImportJSONError() helper adds an error from decoding a line of JSON
+// to the validator, against the field that it relates to if there is one.
+func addImportJSONError(v *validator.Validator, err error) {
+	var unmarshalTypeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, data.ErrInvalidRuntimeFormat):
+		v.AddError("runtime", `must be in the format "<runtime> mins"`)
+	case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+		v.AddError(unmarshalTypeError.Field, "has the wrong JSON type")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		v.AddError("row", fmt.Sprintf("contains unknown key %s", fieldName))
+	default:
+		v.AddError("row", "must be a JSON object")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// invitationTTL is how long an invitation can be used for.
+const invitationTTL = 7 * 24 * time.Hour
+
+// Invite someone to register an account. The invitation token is emailed to
+// the invitee, and is never included in the response. If no role is given,
+// then the invitee will get the default role.
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string   `json:"email"`
+		Role        string   `json:"role"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invitedBy := app.contextGetUser(r).ID
+
+	invitation := &data.Invitation{
+		Email:       input.Email,
+		Role:        input.Role,
+		Permissions: input.Permissions,
+		InvitedBy:   &invitedBy,
+		Expiry:      time.Now().Add(invitationTTL),
+	}
+
+	if invitation.Role == "" {
+		invitation.Role = app.config.defaultRole
+	}
+
+	v := validator.New()
+
+	if data.ValidateInvitation(v, invitation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that the role and every permission code actually exist.
+	_, err = app.models.Roles.Get(invitation.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("role", fmt.Sprintf("%q is not a valid role", invitation.Role))
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range invitation.Permissions {
+		if !all.Include(code) {
+			v.AddError("permissions", fmt.Sprintf("%q is not a valid permission", code))
+			break
+		}
+	}
+
+	// There's no point inviting someone who already has an account.
+	_, err = app.models.Users.GetByEmail(invitation.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Invitations.Insert(invitation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"invitationToken": invitation.Plaintext,
+			"expiry":          invitation.Expiry.UTC().Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(invitation.Email, "invitation.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List all invitations, including ones which have expired.
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := app.models.Invitations.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Withdraw an invitation, so that it can no longer be used to register.
+func (app *application) deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Invitations.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "invitation successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readInvitation() helper checks the invitation token sent when
+// registering, against the registration mode. It returns the invitation, or
+// nil if there wasn't one and registration is open. If registration isn't
+// allowed, then an error response is sent and ok is false.
+func (app *application) readInvitation(
+	w http.ResponseWriter,
+	r *http.Request,
+	v *validator.Validator,
+	email, tokenPlaintext string,
+) (*data.Invitation, bool) {
+	switch {
+	case app.config.registrationMode == "closed":
+		app.registrationClosedResponse(w, r)
+		return nil, false
+	case tokenPlaintext == "" && app.config.registrationMode == "open":
+		return nil, true
+	}
+
+	v.Check(tokenPlaintext != "", "invitation_token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "invitation_token", "must be 26 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	invitation, err := app.models.Invitations.GetForToken(tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation_token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	// The invitation is only for the address that it was sent to. Email
+	// addresses are stored as citext, so they are compared case-insensitively.
+	if !strings.EqualFold(invitation.Email, email) {
+		v.AddError("email", "must match the address that the invitation was sent to")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return invitation, true
+}
+
+// The acceptInvitation() helper gives a newly registered user the role and
+// permissions from their invitation, and then deletes every invitation for
+// their email address.
+func (app *application) acceptInvitation(user *data.User, invitation *data.Invitation) error {
+	err := app.models.Roles.AddForUser(user.ID, invitation.Role)
+	if err != nil {
+		return err
+	}
+
+	if len(invitation.Permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, invitation.Permissions...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.models.Invitations.DeleteAllForEmail(user.Email)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/jwt"
+)
+
+// This file contains the support for the "jwt" token format. In this mode,
+// authentication tokens are signed tokens which carry the user's ID,
+// activation status and permissions, so the authenticate() and
+// requirePermission() middleware don't need to query the database. Each
+// signed token still belongs to a session in the tokens table (its "jti" claim
+// is the session ID), which lets users list and revoke their sessions as
+// usual, and refresh tokens work exactly as they do in "opaque" mode.
+//
+// Since signed tokens can't be deleted, revoking them means adding them to a
+// denylist. The denylist is stored in the token_revocations table so that it
+// is shared by every instance of the API, and each instance keeps a copy in
+// memory which it reloads periodically.
+
+// A userRevocation revokes every session belonging to a user with an ID up to
+// and including sessionID.
+type userRevocation struct {
+	sessionID int64
+	expiry    time.Time
+}
+
+// The revocationList holds the in-memory copy of the denylist. The local
+// field holds the revocations made by this instance, which are kept until they
+// expire: a reload which started just before one of them was committed won't
+// include it, so they are added back in after every reload.
+type revocationList struct {
+	mu       sync.RWMutex
+	sessions map[int64]time.Time
+	families map[string]time.Time
+	users    map[int64]userRevocation
+	local    []*data.Revocation
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{
+		sessions: make(map[int64]time.Time),
+		families: make(map[string]time.Time),
+		users:    make(map[int64]userRevocation),
+	}
+}
+
+// add() adds a revocation to the list. The caller must hold the lock.
+func (l *revocationList) add(revocation *data.Revocation) {
+	if !revocation.AllSessions {
+		if revocation.SessionID != 0 {
+			l.sessions[revocation.SessionID] = revocation.Expiry
+		}
+		if revocation.Family != "" {
+			l.families[revocation.Family] = revocation.Expiry
+		}
+		return
+	}
+
+	existing := l.users[revocation.UserID]
+	l.users[revocation.UserID] = userRevocation{
+		sessionID: max(existing.sessionID, revocation.SessionID),
+		expiry:    maxTime(existing.expiry, revocation.Expiry),
+	}
+}
+
+// insert() adds a revocation which was just made by this instance, so that it
+// takes effect straight away rather than at the next reload.
+func (l *revocationList) insert(revocation *data.Revocation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.add(revocation)
+	l.local = append(l.local, revocation)
+}
+
+// replace() swaps the contents of the list for a freshly loaded set of
+// revocations. Expired revocations are dropped by not being loaded.
+func (l *revocationList) replace(revocations []*data.Revocation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clear(l.sessions)
+	clear(l.families)
+	clear(l.users)
+
+	for _, revocation := range revocations {
+		l.add(revocation)
+	}
+
+	now := time.Now()
+	l.local = slices.DeleteFunc(l.local, func(revocation *data.Revocation) bool {
+		return now.After(revocation.Expiry)
+	})
+
+	for _, revocation := range l.local {
+		l.add(revocation)
+	}
+}
+
+// isRevoked() reports whether a signed token has been revoked.
+func (l *revocationList) isRevoked(claims *jwt.Claims) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if _, ok := l.sessions[claims.ID]; ok {
+		return true
+	}
+
+	if _, ok := l.families[claims.Family]; ok && claims.Family != "" {
+		return true
+	}
+
+	user, ok := l.users[claims.Subject]
+	return ok && claims.ID <= user.sessionID
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// loadRevocations() reloads the denylist from the database.
+func (app *application) loadRevocations() error {
+	revocations, err := app.models.Revocations.GetAll()
+	if err != nil {
+		return err
+	}
+
+	app.revocations.replace(revocations)
+
+	return nil
+}
+
+// syncRevocations() runs in a background goroutine, reloading the denylist so
+// that revocations made by other instances of the API take effect.
+func (app *application) syncRevocations() {
+	ticker := time.NewTicker(app.config.tokens.denylistRefresh)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := app.loadRevocations()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	}
+}
+
+// signAccessToken() replaces the plaintext of a newly created authentication
+// token with a signed token for the same session. The opaque plaintext is
+// never sent to the client, so the row in the tokens table is only used to
+// record the session. If the token is scoped, then only the permissions that
+// it is restricted to are included in the claims.
+func (app *application) signAccessToken(access *data.Token) error {
+	user, err := app.models.Users.Get(access.UserID)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(access.UserID)
+	if err != nil {
+		return err
+	}
+
+	scoped := access.Permissions != nil
+
+	if scoped {
+		permissions = slices.DeleteFunc(permissions, func(code string) bool {
+			return !access.Permissions.Include(code)
+		})
+	}
+
+	signed, err := app.jwt.Sign(jwt.Claims{
+		Subject:     user.ID,
+		ID:          access.ID,
+		IssuedAt:    time.Now().Unix(),
+		Expiry:      access.Expiry.Unix(),
+		Activated:   user.Activated,
+		Permissions: permissions,
+		Scoped:      scoped,
+		Family:      access.Family,
+	})
+	if err != nil {
+		return err
+	}
+
+	access.Plaintext = signed
+
+	return nil
+}
+
+// The authenticateJWT() helper authenticates a request using a signed access
+// token, without touching the database. The user added to the request context
+// only has its ID and Activated fields set; handlers which need the rest of
+// the user's details must be wrapped with the loadUser() middleware.
+func (app *application) authenticateJWT(
+	w http.ResponseWriter,
+	r *http.Request,
+	next http.Handler,
+	token string,
+) {
+	claims, err := app.jwt.Verify(token, time.Now())
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if app.revocations.isRevoked(claims) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user := &data.User{
+		ID:        claims.Subject,
+		Activated: claims.Activated,
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetClaims(r, claims)
+
+	// A scoped token carries only the permissions that it is restricted to,
+	// so record the restriction just like we do for an opaque token.
+	if claims.Scoped {
+		r = app.contextSetPermissions(r, claims.Permissions)
+	}
+
+	next.ServeHTTP(w, r)
+}
+
+// The loadUser() middleware makes sure that the user in the request context
+// has all of their details loaded. Requests authenticated with a signed access
+// token only carry the user's ID, so in that case the user is fetched from the
+// database. For any other request this does nothing.
+func (app *application) loadUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := app.contextGetClaims(r); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetUser(r, user))
+	}
+}
+
+// revokeSessionTokens() revokes the signed access tokens for one of a user's
+// sessions, including the ones issued to it before it was last refreshed,
+// which are identified by the session's token family. It does nothing unless
+// signed tokens are enabled, since otherwise deleting the session from the
+// tokens table is enough.
+func (app *application) revokeSessionTokens(userID, sessionID int64, family string) error {
+	if app.jwt == nil {
+		return nil
+	}
+
+	revocation, err := app.models.Revocations.RevokeSession(userID, sessionID, family)
+	if err != nil {
+		return err
+	}
+
+	app.revocations.insert(revocation)
+
+	return nil
+}
+
+// revokeUserTokens() revokes the signed access tokens for all of a user's
+// sessions. As well as logging users out, this is used when a user's
+// permissions or activation status change, so that their signed tokens stop
+// carrying stale claims: if they still have a refresh token, they can use it
+// to get a new signed token with the up-to-date claims.
+func (app *application) revokeUserTokens(userID int64) error {
+	if app.jwt == nil {
+		return nil
+	}
+
+	revocation, err := app.models.Revocations.RevokeUser(userID)
+	if err != nil {
+		return err
+	}
+
+	app.revocations.insert(revocation)
+
+	return nil
+}
+
+// getSessions() returns the user's sessions, marking the one that the request
+// was authenticated with as the current session.
+func (app *application) getSessions(r *http.Request, userID int64) ([]*data.Session, error) {
+	claims, isJWT := app.contextGetClaims(r)
+
+	sessions, err := app.models.Tokens.GetAllSessionsForUser(userID,
+		app.contextGetToken(r))
+	if err != nil {
+		return nil, err
+	}
+
+	// For signed tokens, the current session is the one named by the "jti"
+	// claim.
+	if isJWT {
+		for _, session := range sessions {
+			session.Current = session.ID == claims.ID
+		}
+	}
+
+	return sessions, nil
+}
@@ -14,7 +14,10 @@ import (
 	"time"
 
 	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/hasher"
+	"github.com/kjloveless/greenlight/internal/jwt"
 	"github.com/kjloveless/greenlight/internal/mailer"
+	"github.com/kjloveless/greenlight/internal/oidc"
   "github.com/kjloveless/greenlight/internal/vcs"
 
 	"github.com/joho/godotenv"
@@ -65,6 +68,52 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// The tokens struct holds the lifetimes of the authentication (access) and
+	// refresh tokens issued when a user logs in, and the format of the
+	// authentication tokens: either "opaque" tokens which are looked up in the
+	// database, or signed "jwt" tokens which are verified with the jwtKeys.
+	tokens struct {
+		authenticationTTL time.Duration
+		refreshTTL        time.Duration
+		format            string
+		jwtKeys           []jwt.Key
+		denylistRefresh   time.Duration
+	}
+	// The lockout struct holds the policy for temporarily locking user
+	// accounts after repeated failed login attempts.
+	lockout data.LockoutPolicy
+	// The maximum number of Argon2id password hashes which can be computed at
+	// once. Each one uses 64 MiB of memory while it runs.
+	passwordHashConcurrency int
+	// The name of the role which is given to newly registered users.
+	defaultRole string
+	// Who can register a new account: anyone ("open"), only people who have
+	// been sent an invitation ("invite"), or nobody ("closed").
+	registrationMode string
+	// The oidc struct holds the settings for logging in with an external
+	// OpenID Connect identity provider. Leaving the issuer empty disables it.
+	oidc struct {
+		issuer        string
+		clientID      string
+		clientSecret  string
+		redirectURL   string
+		autoProvision bool
+	}
+	// The accounts struct holds the settings for deleting user accounts: how
+	// long a user has to cancel a deletion, and how often deleted accounts are
+	// purged.
+	accounts struct {
+		deletionGracePeriod time.Duration
+		purgeInterval       time.Duration
+	}
+	// The imports struct holds the limits for bulk movie imports: the largest
+	// body that can be imported, the number of movies inserted in each
+	// transaction, and how long an import can take to read.
+	imports struct {
+		maxBytes  int64
+		batchSize int
+		timeout   time.Duration
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers,
@@ -81,6 +130,14 @@ type application struct {
 	models data.Models
 	mailer *mailer.Mailer
 	wg     sync.WaitGroup
+	// The OpenID Connect provider, which is nil if OIDC login is disabled, and
+	// the logins which are currently in progress.
+	oidc       *oidc.Provider
+	oidcLogins *oidcLoginStore
+	// The signer for signed access tokens, which is nil unless the "jwt" token
+	// format is in use, and the in-memory copy of the denylist.
+	jwt         *jwt.Signer
+	revocations *revocationList
 }
 
 func main() {
@@ -146,6 +203,79 @@ func main() {
 			return nil
 		})
 
+	// Read the token lifetimes. Authentication tokens are deliberately
+	// short-lived; clients use their refresh token to get a new one.
+	flag.DurationVar(&cfg.tokens.authenticationTTL, "token-authentication-ttl",
+		time.Hour, "Authentication token lifetime")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour,
+		"Refresh token lifetime")
+
+	// Read the authentication token format. The signing keys for the "jwt"
+	// format are a space-separated list of <id>:<base64 secret> pairs; the
+	// first key is used to sign new tokens, and the others are only used to
+	// verify existing ones, which allows keys to be rotated. If the flag isn't
+	// given, the keys are read from the JWT_KEYS environment variable.
+	flag.StringVar(&cfg.tokens.format, "token-format", "opaque",
+		"Authentication token format (opaque|jwt)")
+	flag.Func("jwt-keys", "JWT signing keys (space separated <id>:<base64 secret>)",
+		func(val string) error {
+			keys, err := jwt.ParseKeys(val)
+			cfg.tokens.jwtKeys = keys
+			return err
+		})
+	flag.DurationVar(&cfg.tokens.denylistRefresh, "jwt-denylist-refresh",
+		10*time.Second, "Interval between reloads of the JWT denylist")
+
+	// Read the account lockout settings. After every lockout-threshold
+	// consecutive failed logins the account is locked, for lockout-duration at
+	// first and doubling each time after that, up to lockout-max-duration. Note
+	// the trade-off here: anyone who knows a user's email address can keep
+	// their account locked by repeatedly sending wrong passwords.
+	flag.IntVar(&cfg.lockout.Threshold, "lockout-threshold", 5,
+		"Failed login attempts before an account is locked (0 disables lockouts; "+
+			"anyone who knows an email address can keep its account locked)")
+	flag.DurationVar(&cfg.lockout.Duration, "lockout-duration", 15*time.Minute,
+		"Initial account lockout duration")
+	flag.DurationVar(&cfg.lockout.MaxDuration, "lockout-max-duration",
+		24*time.Hour, "Maximum account lockout duration")
+
+	flag.IntVar(&cfg.passwordHashConcurrency, "password-hash-concurrency", 4,
+		"Maximum number of password hashes computed at once (64 MiB each)")
+
+	flag.StringVar(&cfg.defaultRole, "default-role", "viewer",
+		"Role given to newly registered users")
+	flag.StringVar(&cfg.registrationMode, "registration-mode", "open",
+		"Who can register an account (open|invite|closed)")
+
+	// Read the OpenID Connect provider settings. The client secret defaults to
+	// the OIDC_CLIENT_SECRET environment variable, so that it doesn't have to
+	// appear on the command line.
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "",
+		"OpenID Connect issuer URL (empty to disable)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "",
+		"OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret",
+		os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url",
+		"http://localhost:4000/v1/oidc/callback", "OpenID Connect redirect URL")
+	flag.BoolVar(&cfg.oidc.autoProvision, "oidc-auto-provision", true,
+		"Create accounts for new users who log in with OpenID Connect")
+
+	// Read the account deletion settings.
+	flag.DurationVar(&cfg.accounts.deletionGracePeriod,
+		"account-deletion-grace-period", 7*24*time.Hour,
+		"Time before a deleted account is purged")
+	flag.DurationVar(&cfg.accounts.purgeInterval, "account-purge-interval",
+		time.Hour, "Interval between purges of deleted accounts")
+
+	// Read the bulk movie import settings.
+	flag.Int64Var(&cfg.imports.maxBytes, "import-max-bytes", 50<<20,
+		"Largest request body accepted by a movie import")
+	flag.IntVar(&cfg.imports.batchSize, "import-batch-size", 500,
+		"Number of movies inserted in each transaction of a movie import")
+	flag.DurationVar(&cfg.imports.timeout, "import-timeout", 5*time.Minute,
+		"Time allowed to read and write a movie import")
+
   // Create a new version boolean flag with the default value of false.
   displayVersion := flag.Bool("version", false, "Display version and exit.")
 
@@ -158,6 +288,17 @@ func main() {
     os.Exit(0)
   }
 
+	// Movies are imported in batches of import-batch-size, so anything less than
+	// one would mean nothing could ever be imported.
+	if cfg.imports.batchSize < 1 {
+		logger.Error("invalid import batch size", "size", cfg.imports.batchSize)
+		os.Exit(1)
+	}
+
+	// Limit how many passwords can be hashed at once, to bound the memory
+	// used by Argon2id.
+	hasher.SetArgon2idConcurrency(cfg.passwordHashConcurrency)
+
 	// Call the openDB() helper function (see below) to create the connection
 	// pool, passing in the config struct. If this returns an error, we log it
 	// and exit the application immediately.
@@ -205,12 +346,94 @@ func main() {
 	// Declare an instance of the application struct, containing the config
 	// struct and the logger.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
+		config:     cfg,
+		logger:     logger,
+		models:     data.NewModels(db),
+		mailer:     mailer,
+		oidcLogins: newOIDCLoginStore(),
+	}
+
+	// If an OpenID Connect provider has been configured, then fetch its
+	// metadata now, so that any problems are found at startup.
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		app.oidc, err = oidc.Discover(ctx, cfg.oidc.issuer, cfg.oidc.clientID,
+			cfg.oidc.clientSecret, cfg.oidc.redirectURL)
+		cancel()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		logger.Info("openid connect provider discovered", "issuer", app.oidc.Issuer)
 	}
 
+	// If signed access tokens are in use, then set up the signer and load the
+	// denylist, and start a background goroutine to keep the denylist up to
+	// date.
+	switch cfg.tokens.format {
+	case "opaque":
+	case "jwt":
+		if cfg.tokens.jwtKeys == nil {
+			cfg.tokens.jwtKeys, err = jwt.ParseKeys(os.Getenv("JWT_KEYS"))
+			if err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// Revocations are only kept for data.RevocationRetention, so a signed
+		// token mustn't outlive that.
+		if cfg.tokens.authenticationTTL > data.RevocationRetention {
+			logger.Error("token-authentication-ttl is too long for the jwt token format",
+				"max", data.RevocationRetention.String())
+			os.Exit(1)
+		}
+
+		app.jwt, err = jwt.NewSigner("greenlight", cfg.tokens.jwtKeys...)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		app.revocations = newRevocationList()
+
+		err = app.loadRevocations()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		go app.syncRevocations()
+	default:
+		logger.Error("invalid token format", "format", cfg.tokens.format)
+		os.Exit(1)
+	}
+
+	switch cfg.registrationMode {
+	case "open", "invite", "closed":
+	default:
+		logger.Error("invalid registration mode", "mode", cfg.registrationMode)
+		os.Exit(1)
+	}
+
+	// Check that the default role actually exists, so that a typo in the
+	// -default-role flag is caught at startup rather than when the first user
+	// registers.
+	_, err = app.models.Roles.Get(cfg.defaultRole)
+	if err != nil {
+		logger.Error("invalid default role", "role", cfg.defaultRole, "error", err.Error())
+		os.Exit(1)
+	}
+
+	// Start a background goroutine to purge accounts once their deletion
+	// grace period has passed.
+	go app.purgeDeletedAccounts()
+
+	// And another to prune old failed login records for unknown email
+	// addresses.
+	go app.pruneUnknownLoginFailures()
+
 	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
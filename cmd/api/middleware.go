@@ -11,8 +11,10 @@ import (
 	"time"
 
 	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/jwt"
 	"github.com/kjloveless/greenlight/internal/validator"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
@@ -197,6 +199,20 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication token from the header parts.
 		token := headerParts[1]
 
+		// API keys are also sent as bearer tokens, but are distinguished by their
+		// prefix. If we've got one, authenticate it separately.
+		if data.IsAPIKey(token) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		// When signed access tokens are enabled, they are verified without
+		// touching the database.
+		if app.jwt != nil && jwt.IsJWT(token) {
+			app.authenticateJWT(w, r, next, token)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
@@ -223,15 +239,71 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Record that the token has been used, so that it shows up correctly in
+		// the user's session list.
+		err = app.models.Tokens.Touch(data.ScopeAuthentication, token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// If the token is scoped, then look up the permissions that it is
+		// restricted to.
+		permissions, scoped, err := app.models.Permissions.GetAllForToken(token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
 		// Call the contextSetUser() helper to add the user information to the
-		// request context.
+		// request context, and store the token too so that it can be revoked
+		// later.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
+
+		if scoped {
+			r = app.contextSetPermissions(r, permissions)
+		}
 
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
 }
 
+// The authenticateAPIKey() helper authenticates a request using an API key
+// rather than an authentication token. On success, the owner of the key is
+// added to the request context, along with the permissions that the key is
+// restricted to.
+func (app *application) authenticateAPIKey(
+	w http.ResponseWriter,
+	r *http.Request,
+	next http.Handler,
+	key string,
+) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, key); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, permissions, err := app.models.APIKeys.GetForKey(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, permissions)
+
+	next.ServeHTTP(w, r)
+}
+
 // Create a new requireAuthenticatedUser() middleware to check that a user is
 // not anonymous.
 func (app *application) requireAuthenticatedUser(
@@ -274,6 +346,25 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// The requireUnrestrictedCredential() middleware checks that the request
+// wasn't made with a credential that is restricted to a subset of the user's
+// permissions. It's used for endpoints which aren't guarded by a permission,
+// like the ones for managing the user's account, and so couldn't otherwise
+// honour the restriction. It only checks that the user is authenticated, so
+// wrap next with requireActivatedUser() as well if that's needed.
+func (app *application) requireUnrestrictedCredential(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := app.contextGetPermissions(r); ok {
+			app.restrictedCredentialResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 // Note that the first parameter for the middleware function is the permission
 // code that we require the user to have.
 func (app *application) requirePermission(code string,
@@ -283,11 +374,21 @@ func (app *application) requirePermission(code string,
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
 
-		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		// Get the slice of permissions for the user. If the request was
+		// authenticated with a signed access token, the permissions are in its
+		// claims, so we don't need to look them up.
+		var permissions data.Permissions
+
+		if claims, ok := app.contextGetClaims(r); ok {
+			permissions = claims.Permissions
+		} else {
+			var err error
+
+			permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		// Check if the slice includes the required permission. If it doesn't, then
@@ -297,6 +398,14 @@ func (app *application) requirePermission(code string,
 			return
 		}
 
+		// If the request's credential is restricted to a subset of permissions
+		// (like an API key or a scoped token is), then the required permission
+		// must be in that subset too.
+		if restricted, ok := app.contextGetPermissions(r); ok && !restricted.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		// Otherwise, they have the required permission so we call the next handler
 		// in the chain.
 		next.ServeHTTP(w, r)
@@ -306,6 +415,73 @@ func (app *application) requirePermission(code string,
 	return app.requireActivatedUser(fn)
 }
 
+// The requireOrganization() middleware works out which organization the
+// request is operating within, and checks that the user has at least the given
+// role in it. The organization is chosen with the X-Organization-ID header; if
+// the header is missing and the user only belongs to one organization, then
+// that one is used. The user's membership is added to the request context for
+// the handlers to use.
+func (app *application) requireOrganization(role string,
+	next http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		var membership *data.Membership
+
+		header := r.Header.Get("X-Organization-ID")
+
+		if header == "" {
+			organizations, err := app.models.Organizations.GetAllForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			switch len(organizations) {
+			case 0:
+				app.notOrganizationMemberResponse(w, r)
+				return
+			case 1:
+				membership = &data.Membership{
+					OrganizationID: organizations[0].ID,
+					UserID:         user.ID,
+					Role:           organizations[0].Role,
+				}
+			default:
+				app.badRequestResponse(w, r,
+					errors.New("the X-Organization-ID header must be provided when you belong to more than one organization"))
+				return
+			}
+		} else {
+			organizationID, err := strconv.ParseInt(header, 10, 64)
+			if err != nil || organizationID < 1 {
+				app.badRequestResponse(w, r,
+					errors.New("the X-Organization-ID header must be a positive integer"))
+				return
+			}
+
+			membership, err = app.models.Organizations.GetMembership(organizationID, user.ID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notOrganizationMemberResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+		}
+
+		if !membership.HasRole(role) {
+			app.organizationRoleRequiredResponse(w, r, role)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetMembership(r, membership))
+	}
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter,
 		r *http.Request,
@@ -340,7 +516,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 						w.Header().Set("Access-Control-Allow-Methods",
 							"OPTIONS, PUT, PATCH, DELETE")
 						w.Header().Set("Access-Control-Allow-Headers",
-							"Authorization, Content-Type")
+							"Authorization, Content-Type, X-Organization-ID")
 
 						// Write the headers along with a 200 OK status and return from
 						// the middleware with no further action.
@@ -402,3 +578,20 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalProcessingTimeMicroseconds.Add(duration)
 	})
 }
+
+// The matchParam() middleware only passes a request on to the next handler if
+// the named URL parameter has the given value, and sends a 405 Method Not
+// Allowed response otherwise. It lets a route with a static path segment be
+// registered where httprouter only allows a parameter.
+func (app *application) matchParam(name, value string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if params.ByName(name) != value {
+			app.methodNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -33,12 +33,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Copy the values from the input struct to a new Movie struct.
+	// Copy the values from the input struct to a new Movie struct. The movie is
+	// added to the catalog of the organization that the request is operating
+	// within.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		OrganizationID: app.contextGetMembership(r).OrganizationID,
+		Title:          input.Title,
+		Year:           input.Year,
+		Runtime:        input.Runtime,
+		Genres:         input.Genres,
 	}
 
 	// Initialize a new Validator instance.
@@ -96,8 +99,9 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	// Call the Get() method to fetch the data for a specific movie. We also need
 	// to use the errors.Is() function to check if it returns a
 	// data.ErrRecordNotFound error, in which case we send a 404 Not Found
-	// response to the client.
-	movie, err := app.models.Movies.Get(id)
+	// response to the client. Movies in other organizations' catalogs are
+	// reported as not found too, so that their IDs aren't revealed.
+	movie, err := app.models.Movies.Get(id, app.contextGetMembership(r).OrganizationID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -125,7 +129,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Fetch the existing movie record from the database, sending a 404 Not Found
 	// response to the client if we couldn't find a matching record.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(id, app.contextGetMembership(r).OrganizationID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -215,7 +219,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Delete the movie from the database, sending a 404 Not Found response to
 	// the client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(id, app.contextGetMembership(r).OrganizationID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -239,8 +243,10 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// struct to hold the expected values from the request query string.
 	// Embed the new Filters struct.
 	var input struct {
-		Title  string
-		Genres []string
+		Title    string
+		Genres   []string
+		Director string
+		Cast     string
 		data.Filters
 	}
 
@@ -257,6 +263,11 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// The director and cast values filter by the names of the people credited
+	// on the movie as a director or an actor respectively.
+	input.Director = app.readString(qs, "director", "")
+	input.Cast = app.readString(qs, "cast", "")
+
 	// Get the page and page_size query string values as integers. Notice that we
 	// set the default page value to 1 and default page_size to 20, and that we
 	// pass the validator instance as the final argument here.
@@ -270,8 +281,8 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	// Add the supported sort values for this endpoint to the sort safelist.
 	input.Filters.SortSafeList = []string{
-		"id", "title", "year", "runtime",
-		"-id", "-title", "-year", "-runtime",
+		"id", "title", "year", "runtime", "average_rating", "rating_count",
+		"-id", "-title", "-year", "-runtime", "-average_rating", "-rating_count",
 	}
 
 	// Check the Validator instance for any errors and use the
@@ -282,11 +293,15 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Call the GetAll() method to retrieve the movies, passing in the various
-	// filter parameters.
+	// Call the GetAll() method to retrieve the movies from the active
+	// organization's catalog, passing in the various filter parameters.
 	movies, metadata, err := app.models.Movies.GetAll(
+		app.contextGetMembership(r).OrganizationID,
+		app.contextGetUser(r).ID,
 		input.Title,
 		input.Genres,
+		input.Director,
+		input.Cast,
 		input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -300,3 +315,27 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The readMovie() helper fetches the movie named by the "id" URL parameter from
+// the active organization's catalog. If there is no such movie, or anything
+// else goes wrong, an error response is sent and ok is false.
+func (app *application) readMovie(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id, app.contextGetMembership(r).OrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
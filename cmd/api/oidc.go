@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/oidc"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// oidcLoginTTL is how long a user has to complete a login at the identity
+// provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// An oidcLogin holds the secrets for a login which is in progress, until the
+// user comes back from the identity provider.
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	expiry   time.Time
+}
+
+// The oidcLoginStore holds in-progress logins in memory, keyed by their state
+// value. Like the rate limiter's clients map, this means that the login has to
+// be completed against the same instance of the API that it was started on.
+type oidcLoginStore struct {
+	mu     sync.Mutex
+	logins map[string]oidcLogin
+}
+
+func newOIDCLoginStore() *oidcLoginStore {
+	return &oidcLoginStore{logins: make(map[string]oidcLogin)}
+}
+
+// add() stores a new login, and takes the opportunity to clear out any logins
+// which were never completed.
+func (s *oidcLoginStore) add(state string, login oidcLogin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, l := range s.logins {
+		if now.After(l.expiry) {
+			delete(s.logins, key)
+		}
+	}
+
+	s.logins[state] = login
+}
+
+// take() removes and returns the login for a state value, so that each state
+// can only be used once.
+func (s *oidcLoginStore) take(state string) (oidcLogin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login, ok := s.logins[state]
+	delete(s.logins, state)
+
+	if !ok || time.Now().After(login.expiry) {
+		return oidcLogin{}, false
+	}
+
+	return login, true
+}
+
+// Start logging in with the configured OpenID Connect provider, by redirecting
+// the user to the provider's authorization endpoint.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	state := oidc.RandomString()
+	verifier := oidc.RandomString()
+
+	login := oidcLogin{
+		nonce:    oidc.RandomString(),
+		verifier: verifier,
+		expiry:   time.Now().Add(oidcLoginTTL),
+	}
+
+	app.oidcLogins.add(state, login)
+
+	http.Redirect(w, r,
+		app.oidc.AuthCodeURL(state, login.nonce, oidc.S256Challenge(verifier)),
+		http.StatusFound)
+}
+
+// Complete a login with the configured OpenID Connect provider. The provider
+// redirects the user here with an authorization code, which we exchange for an
+// ID token. Once the ID token has been validated, the user is linked to (or
+// provisioned as) a Greenlight user and logged in just like they would be with
+// a password.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// If the user cancelled the login, or the provider refused it, then the
+	// provider sends an error instead of a code.
+	if providerError := qs.Get("error"); providerError != "" {
+		app.oidcFailedResponse(w, r, "login failed at the identity provider: "+providerError)
+		return
+	}
+
+	v := validator.New()
+
+	state := app.readString(qs, "state", "")
+	code := app.readString(qs, "code", "")
+
+	v.Check(state != "", "state", "must be provided")
+	v.Check(code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, ok := app.oidcLogins.take(state)
+	if !ok {
+		v.AddError("state", "invalid or expired login state")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	rawIDToken, err := app.oidc.Exchange(ctx, code, login.verifier)
+	if err != nil {
+		app.logger.Warn("oidc code exchange failed", "error", err.Error())
+		app.oidcFailedResponse(w, r, "the authorization code could not be exchanged")
+		return
+	}
+
+	claims, err := app.oidc.Verify(ctx, rawIDToken, login.nonce, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken):
+			app.logger.Warn("invalid oidc id token", "error", err.Error())
+			app.oidcFailedResponse(w, r, "the identity provider returned an invalid ID token")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.linkOIDCUser(claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOIDCEmailNotVerified):
+			app.oidcFailedResponse(w, r, "your email address has not been verified by the identity provider")
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oidcFailedResponse(w, r, "there is no account for this email address")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkAccountDeletion(w, r, user) {
+		return
+	}
+
+	app.completeLogin(w, r, user, sessionOptions{})
+}
+
+var errOIDCEmailNotVerified = errors.New("oidc email address not verified")
+
+// linkOIDCUser() returns the user for an external identity. If the identity
+// hasn't been seen before, then it is linked to the user with the same email
+// address, or a new user is provisioned if there is no such user and
+// auto-provisioning is enabled. Both of these rely on the email address, so
+// we only do them if the provider says that it has verified the address. Since
+// auto-provisioning is a form of registration, it only happens when
+// registration is open.
+func (app *application) linkOIDCUser(claims *oidc.Claims) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(claims.Issuer, claims.Subject)
+	if err == nil || !errors.Is(err, data.ErrRecordNotFound) {
+		return user, err
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return nil, errOIDCEmailNotVerified
+	}
+
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// The provider has verified the email address, so if the account was
+		// waiting to be activated, it can be activated now.
+		if !user.Activated {
+			user.Activated = true
+
+			err = app.models.Users.Update(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound) && app.config.oidc.autoProvision &&
+		app.config.registrationMode == "open":
+		user, err = app.provisionOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	identity := &data.Identity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+	}
+
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// provisionOIDCUser() creates a new, activated user for an external identity,
+// with the default role. The user is given a random password which nobody
+// knows; if they ever want to log in with a password, they can reset it.
+func (app *application) provisionOIDCUser(claims *oidc.Claims) (*data.User, error) {
+	name := claims.Name
+	if name == "" || len(name) > 500 {
+		name = claims.Email
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	err := user.Password.Set(rand.Text() + rand.Text())
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, user.Email); !v.Valid() {
+		return nil, errOIDCEmailNotVerified
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, app.config.defaultRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// List the organizations that the current user is a member of, along with
+// their role in each one.
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	organizations, err := app.models.Organizations.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organizations": organizations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create a new organization. The user who creates it becomes its first admin.
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	organization := &data.Organization{
+		Name: input.Name,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, organization); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Insert(organization, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/organizations/%d", organization.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": organization}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.readOrganization(w, r, data.OrganizationRoleViewer)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"organization": organization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Rename an organization. Only the organization's admins can do this.
+func (app *application) updateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.readOrganization(w, r, data.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		organization.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, organization); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Update(organization)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": organization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete an organization, along with its whole movie catalog. Only the
+// organization's admins can do this.
+func (app *application) deleteOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.readOrganization(w, r, data.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+
+	err := app.models.Organizations.Delete(organization.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "organization successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the members of an organization. Any member can see who the other
+// members are.
+func (app *application) listOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.readOrganization(w, r, data.OrganizationRoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := app.models.Organizations.GetAllMembers(organization.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add an existing user to an organization, identified by their email address.
+// Only the organization's admins can do this. So that the endpoint can't be
+// used to find out which email addresses have accounts, the response is the
+// same whether or not a user was added.
+func (app *application) addOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.readOrganization(w, r, data.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidateOrganizationRole(v, input.Role)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an account with this email address exists and isn't already a member, it has been added to the organization"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Changing an existing member's role is done with the PATCH endpoint, so
+	// AddMember() leaves the role of anyone who is already a member alone. That
+	// way adding a member can never demote an organization's last admin.
+	err = app.models.Organizations.AddMember(organization.ID, user.ID, input.Role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Change a member's role. Only the organization's admins can do this, and the
+// last admin can't be demoted.
+func (app *application) updateOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.readOrganization(w, r, data.OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+
+	member, ok := app.readMember(w, r, organization)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganizationRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.UpdateMemberRole(organization.ID, member.UserID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastOrganizationAdmin):
+			app.lastOrganizationAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	member.Role = input.Role
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Remove a member from an organization. Admins can remove anyone, and any
+// member can remove themselves, but the last admin can't leave.
+func (app *application) removeOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.readOrganization(w, r, data.OrganizationRoleViewer)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readInt64Param(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if userID != app.contextGetUser(r).ID && !organization.HasRole(data.OrganizationRoleAdmin) {
+		app.organizationRoleRequiredResponse(w, r, data.OrganizationRoleAdmin)
+		return
+	}
+
+	member, ok := app.readMember(w, r, organization)
+	if !ok {
+		return
+	}
+
+	err = app.models.Organizations.RemoveMember(organization.ID, member.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastOrganizationAdmin):
+			app.lastOrganizationAdminResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readOrganization() helper fetches the organization named by the "id" URL
+// parameter, and checks that the current user has at least the given role in
+// it. Organizations that the user isn't a member of are reported as not found,
+// so that their existence isn't revealed. If anything goes wrong, an error
+// response is sent and ok is false.
+func (app *application) readOrganization(
+	w http.ResponseWriter,
+	r *http.Request,
+	role string,
+) (*data.Organization, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	organization, err := app.models.Organizations.GetForUser(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !organization.HasRole(role) {
+		app.organizationRoleRequiredResponse(w, r, role)
+		return nil, false
+	}
+
+	return organization, true
+}
+
+// The readMember() helper fetches the membership named by the "user_id" URL
+// parameter.
+func (app *application) readMember(
+	w http.ResponseWriter,
+	r *http.Request,
+	organization *data.Organization,
+) (*data.Membership, bool) {
+	userID, err := app.readInt64Param(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	member, err := app.models.Organizations.GetMembership(organization.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return member, true
+}
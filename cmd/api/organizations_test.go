@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kjloveless/greenlight/internal/data"
+)
+
+// TestOrganizationScoping checks that a member of one organization can't see
+// or change anything in another organization's catalog. Alice and Bob each
+// belong only to their own organization, and everything that Alice tries to
+// reach belongs to Bob's.
+func TestOrganizationScoping(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(db)
+	h := app.routes()
+
+	alice := insertTestUser(t, app, "Alice")
+	bob := insertTestUser(t, app, "Bob")
+
+	insertMovie := func(user *testUser, title string) *data.Movie {
+		t.Helper()
+
+		movie := &data.Movie{
+			OrganizationID: user.organization.ID,
+			Title:          title,
+			Year:           2001,
+			Runtime:        100,
+			Genres:         []string{"drama"},
+		}
+
+		err := app.models.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return movie
+	}
+
+	aliceMovie := insertMovie(alice, "Alice's movie")
+	bobMovie := insertMovie(bob, "Bob's movie")
+
+	bobReview := &data.Review{MovieID: bobMovie.ID, UserID: bob.ID, Score: 4}
+	if err := app.models.Reviews.Insert(bobReview); err != nil {
+		t.Fatal(err)
+	}
+
+	bobPerson := &data.Person{OrganizationID: bob.organization.ID, Name: "Bob's director"}
+	if err := app.models.People.Insert(bobPerson); err != nil {
+		t.Fatal(err)
+	}
+
+	bobCredit := &data.Credit{
+		MovieID:  bobMovie.ID,
+		PersonID: bobPerson.ID,
+		Name:     bobPerson.Name,
+		Role:     data.CreditRoleDirector,
+	}
+	if err := app.models.Credits.Insert(bobCredit); err != nil {
+		t.Fatal(err)
+	}
+
+	bobCollection := &data.Collection{OrganizationID: bob.organization.ID, Name: "Bob's collection"}
+	if err := app.models.Collections.Insert(bobCollection, []int64{bobMovie.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceCollection := &data.Collection{OrganizationID: alice.organization.ID, Name: "Alice's collection"}
+	if err := app.models.Collections.Insert(aliceCollection, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Put Bob's movie on Alice's watchlist directly, as if she had added it
+	// while she was a member of Bob's organization.
+	entry := &data.WatchlistEntry{UserID: alice.ID, Movie: bobMovie}
+	if err := app.models.Watchlist.Insert(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("requests for another organization's records", func(t *testing.T) {
+		movie := fmt.Sprintf("/v1/movies/%d", bobMovie.ID)
+		review := fmt.Sprintf("%s/reviews/%d", movie, bobReview.ID)
+		person := fmt.Sprintf("/v1/people/%d", bobPerson.ID)
+		credit := fmt.Sprintf("/v1/credits/%d", bobCredit.ID)
+		collection := fmt.Sprintf("/v1/collections/%d", bobCollection.ID)
+		watchlistEntry := fmt.Sprintf("/v1/users/me/watchlist/%d", bobMovie.ID)
+
+		tests := []struct {
+			method   string
+			path     string
+			body     string
+			wantCode int
+		}{
+			{http.MethodGet, movie, "", http.StatusNotFound},
+			{http.MethodPatch, movie, `{"title": "Stolen"}`, http.StatusNotFound},
+			{http.MethodDelete, movie, "", http.StatusNotFound},
+
+			{http.MethodGet, movie + "/reviews", "", http.StatusNotFound},
+			{http.MethodPost, movie + "/reviews", `{"score": 1}`, http.StatusNotFound},
+			{http.MethodGet, review, "", http.StatusNotFound},
+			{http.MethodPatch, review, `{"score": 1}`, http.StatusNotFound},
+			{http.MethodDelete, review, "", http.StatusNotFound},
+			{http.MethodGet, fmt.Sprintf("/v1/movies/%d/reviews/%d", aliceMovie.ID, bobReview.ID), "", http.StatusNotFound},
+
+			{http.MethodGet, movie + "/credits", "", http.StatusNotFound},
+			{http.MethodGet, person, "", http.StatusNotFound},
+			{http.MethodPatch, person, `{"name": "Stolen"}`, http.StatusNotFound},
+			{http.MethodDelete, person, "", http.StatusNotFound},
+			{http.MethodGet, person + "/movies", "", http.StatusNotFound},
+			{http.MethodGet, credit, "", http.StatusNotFound},
+			{http.MethodPatch, credit, `{"character": "Stolen"}`, http.StatusNotFound},
+			{http.MethodDelete, credit, "", http.StatusNotFound},
+			{http.MethodPost, "/v1/credits", fmt.Sprintf(`{"movie_id": %d, "person_id": %d, "role": "actor"}`, aliceMovie.ID, bobPerson.ID), http.StatusUnprocessableEntity},
+			{http.MethodPost, "/v1/credits", fmt.Sprintf(`{"movie_id": %d, "person_id": %d, "role": "actor"}`, bobMovie.ID, bobPerson.ID), http.StatusUnprocessableEntity},
+
+			{http.MethodGet, collection, "", http.StatusNotFound},
+			{http.MethodPatch, collection, `{"name": "Stolen"}`, http.StatusNotFound},
+			{http.MethodDelete, collection, "", http.StatusNotFound},
+			{http.MethodPost, "/v1/collections", fmt.Sprintf(`{"name": "Stolen", "movie_ids": [%d]}`, bobMovie.ID), http.StatusUnprocessableEntity},
+			{http.MethodPatch, fmt.Sprintf("/v1/collections/%d", aliceCollection.ID), fmt.Sprintf(`{"movie_ids": [%d]}`, bobMovie.ID), http.StatusUnprocessableEntity},
+
+			{http.MethodPost, "/v1/users/me/watchlist", fmt.Sprintf(`{"movie_id": %d}`, bobMovie.ID), http.StatusUnprocessableEntity},
+			{http.MethodPatch, watchlistEntry, `{"notes": "Stolen"}`, http.StatusNotFound},
+			{http.MethodDelete, watchlistEntry, "", http.StatusNotFound},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+				code, body := request(t, h, alice, tt.method, tt.path, tt.body)
+				if code != tt.wantCode {
+					t.Errorf("got status %d; want %d (body: %s)", code, tt.wantCode, body)
+				}
+			})
+		}
+	})
+
+	t.Run("another organization's records are unchanged", func(t *testing.T) {
+		movie, err := app.models.Movies.Get(bobMovie.ID, bob.organization.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.Title != bobMovie.Title || movie.Version != bobMovie.Version {
+			t.Errorf("movie changed to %q (version %d)", movie.Title, movie.Version)
+		}
+
+		review, err := app.models.Reviews.Get(bobReview.ID, bobMovie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if review.Score != bobReview.Score {
+			t.Errorf("review score changed to %d", review.Score)
+		}
+
+		person, err := app.models.People.Get(bobPerson.ID, bob.organization.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if person.Name != bobPerson.Name {
+			t.Errorf("person renamed to %q", person.Name)
+		}
+
+		credit, err := app.models.Credits.Get(bobCredit.ID, bob.organization.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if credit.Character != "" {
+			t.Errorf("credit character changed to %q", credit.Character)
+		}
+
+		collection, err := app.models.Collections.Get(bobCollection.ID, bob.organization.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if collection.Name != bobCollection.Name {
+			t.Errorf("collection renamed to %q", collection.Name)
+		}
+
+		collection, err = app.models.Collections.Get(aliceCollection.ID, alice.organization.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if collection.Version != aliceCollection.Version {
+			t.Errorf("collection version changed to %d", collection.Version)
+		}
+	})
+
+	t.Run("lists leave out another organization's records", func(t *testing.T) {
+		tests := []struct {
+			path    string
+			key     string
+			ownID   int64
+			otherID int64
+		}{
+			{"/v1/movies", "movies", aliceMovie.ID, bobMovie.ID},
+			{"/v1/people", "people", 0, bobPerson.ID},
+			{"/v1/collections", "collections", aliceCollection.ID, bobCollection.ID},
+			{"/v1/users/me/watchlist", "watchlist", 0, bobMovie.ID},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.path, func(t *testing.T) {
+				code, body := request(t, h, alice, http.MethodGet, tt.path, "")
+				if code != http.StatusOK {
+					t.Fatalf("got status %d; want %d (body: %s)", code, http.StatusOK, body)
+				}
+
+				ids := listIDs(t, body, tt.key)
+
+				if tt.ownID != 0 && !ids[tt.ownID] {
+					t.Errorf("list doesn't include %d: %s", tt.ownID, body)
+				}
+
+				if ids[tt.otherID] {
+					t.Errorf("list includes %d from another organization: %s", tt.otherID, body)
+				}
+			})
+		}
+	})
+}
+
+// The listIDs() helper returns the IDs of the records in a list response. For
+// watchlist entries, which don't have IDs of their own, the IDs of their
+// movies are used instead.
+func listIDs(t *testing.T, body []byte, key string) map[int64]bool {
+	t.Helper()
+
+	var env map[string]json.RawMessage
+
+	err := json.Unmarshal(body, &env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []struct {
+		ID    int64 `json:"id"`
+		Movie struct {
+			ID int64 `json:"id"`
+		} `json:"movie"`
+	}
+
+	err = json.Unmarshal(env[key], &records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[int64]bool)
+
+	for _, record := range records {
+		ids[record.ID] = true
+		ids[record.Movie.ID] = true
+	}
+
+	return ids
+}
+
+// TestLastOrganizationAdmin checks that an organization's only admin can't
+// demote or remove themselves, or delete their account, while a second admin
+// can be.
+func TestLastOrganizationAdmin(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(db)
+	h := app.routes()
+
+	alice := insertTestUser(t, app, "Alice")
+	bob := insertTestUser(t, app, "Bob")
+
+	member := fmt.Sprintf("/v1/organizations/%d/members/%d", alice.organization.ID, alice.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"demote", http.MethodPatch, member, `{"role": "editor"}`},
+		{"leave", http.MethodDelete, member, ""},
+		{"delete account", http.MethodDelete, "/v1/users/me", `{"password": "pa55word1234"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := request(t, h, alice, tt.method, tt.path, tt.body)
+			if code != http.StatusConflict {
+				t.Errorf("got status %d; want %d (body: %s)", code, http.StatusConflict, body)
+			}
+		})
+	}
+
+	err := app.models.Organizations.AddMember(alice.organization.ID, bob.ID, data.OrganizationRoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("demote with another admin", func(t *testing.T) {
+		code, body := request(t, h, alice, http.MethodPatch, member, `{"role": "editor"}`)
+		if code != http.StatusOK {
+			t.Errorf("got status %d; want %d (body: %s)", code, http.StatusOK, body)
+		}
+	})
+}
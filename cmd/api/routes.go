@@ -4,6 +4,8 @@ import (
 	"expvar"
 	"net/http"
 
+	"github.com/kjloveless/greenlight/internal/data"
+
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -30,32 +32,314 @@ func (app *application) routes() http.Handler {
 	// endpoints using the HandlerFunc() method. Note that http.MethodGet and
 	// http.MethodPost are constants which equate to the strings "GET" and "POST"
 	// respectively.
+	//
+	// The movie endpoints all operate within the caller's active organization,
+	// so as well as the global movies permissions they require the viewer role
+	// in that organization to read movies and the editor role to change them.
 	router.HandlerFunc(http.MethodGet, "/v1/movies",
-		app.requirePermission("movies:read", app.listMoviesHandler))
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listMoviesHandler)))
 
 	router.HandlerFunc(http.MethodPost, "/v1/movies",
-		app.requirePermission("movies:write", app.createMovieHandler))
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.createMovieHandler)))
 
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id",
-		app.requirePermission("movies:read", app.showMovieHandler))
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.showMovieHandler)))
+
+	// Add the route for the POST /v1/movies/import endpoint. httprouter won't
+	// allow the static "import" segment alongside the :id parameter that the
+	// other movie routes use, so the route is registered as /v1/movies/:id and
+	// matchParam() checks that the parameter is "import".
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id",
+		app.matchParam("id", "import",
+			app.requirePermission("movies:write",
+				app.requireOrganization(data.OrganizationRoleEditor, app.importMoviesHandler))))
 
 	// Add the route for the PATCH /v1/movies/:id endpoint.
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id",
-		app.requirePermission("movies:write", app.updateMovieHandler))
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.updateMovieHandler)))
 
 	// Add the route for the DELETE /v1/movies/:id endpoint
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id",
-		app.requirePermission("movies:write", app.deleteMovieHandler))
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.deleteMovieHandler)))
+
+	// Add the routes for reviewing movies. Any member of the organization who
+	// can read its movies can review them, but only a review's author can edit
+	// or delete it.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listMovieReviewsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.createMovieReviewHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.showMovieReviewHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.updateMovieReviewHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.deleteMovieReviewHandler)))
+
+	// Add the routes for the people credited on movies, and for their credits.
+	// Like movies, these belong to the active organization's catalog, and
+	// changing them requires the "movies:write" permission.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listMovieCreditsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/people",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listPeopleHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/people",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.createPersonHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.showPersonHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.updatePersonHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.deletePersonHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id/movies",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listPersonMoviesHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/credits",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.createCreditHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/credits/:id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.showCreditHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/credits/:id",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.updateCreditHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/credits/:id",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.deleteCreditHandler)))
+
+	// Add the routes for collections of movies, which also belong to the
+	// active organization's catalog.
+	router.HandlerFunc(http.MethodGet, "/v1/collections",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listCollectionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/collections",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.createCollectionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.showCollectionHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.updateCollectionHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id",
+		app.requirePermission("movies:write",
+			app.requireOrganization(data.OrganizationRoleEditor, app.deleteCollectionHandler)))
+
+	// Add the routes for managing organizations and their members. Any
+	// activated user can create an organization; what they can do with an
+	// existing one depends on their role in it. None of this is covered by a
+	// permission, so API keys and scoped tokens can't be used for it.
+	router.HandlerFunc(http.MethodGet, "/v1/organizations",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.listOrganizationsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/organizations",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.createOrganizationHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/organizations/:id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.showOrganizationHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/organizations/:id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.updateOrganizationHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/organizations/:id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.deleteOrganizationHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/organizations/:id/members",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.listOrganizationMembersHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/organizations/:id/members",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.addOrganizationMemberHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/organizations/:id/members/:user_id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.updateOrganizationMemberHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/organizations/:id/members/:user_id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.removeOrganizationMemberHandler)))
 
 	// Add the route for the POST /v1/users endpoint.
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	// Add the route for the PUT /v1/users/activated endpoint.
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated",
 		app.activateUserHandler)
+	// Add the PUT /v1/users/password endpoint.
+	router.HandlerFunc(http.MethodPut, "/v1/users/password",
+		app.updateUserPasswordHandler)
+
+	// Add the routes for updating the current user's profile and changing
+	// their email address. Confirming an email change only needs the token that
+	// was sent to the new address, so it doesn't require authentication.
+	//
+	// Handlers which need more of the current user's details than their ID are
+	// wrapped with the loadUser() middleware, since requests authenticated with
+	// a signed access token don't load the user from the database.
+	//
+	// None of the endpoints for managing the current user's account are
+	// covered by a permission, so they're all wrapped with the
+	// requireUnrestrictedCredential() middleware to stop API keys and scoped
+	// tokens from being used for them.
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.loadUser(app.updateCurrentUserHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.loadUser(app.createEmailChangeHandler))))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email",
+		app.confirmEmailChangeHandler)
+
+	// Add the routes for exporting the current user's data and deleting their
+	// account. A deletion can be cancelled during the grace period with the
+	// token from the cancellation email, so that route doesn't require
+	// authentication.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export",
+		app.requireUnrestrictedCredential(app.loadUser(app.exportCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me",
+		app.requireUnrestrictedCredential(app.loadUser(app.deleteCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/deletion/cancel",
+		app.cancelAccountDeletionHandler)
+
+	// Add the routes for listing and revoking the current user's sessions.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions",
+		app.requireUnrestrictedCredential(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id",
+		app.requireUnrestrictedCredential(app.deleteSessionHandler))
+
+	// Add the routes for managing the current user's API keys. A restricted
+	// credential can't be used for these, or a short-lived scoped token could
+	// be swapped for an API key which never expires.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys/:id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.showAPIKeyHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/api-keys/:id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.updateAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.deleteAPIKeyHandler)))
+
+	// Add the routes for the current user's watchlist and watched history.
+	// Entries are identified by the ID of the movie, and like the movie
+	// endpoints they operate within the active organization's catalog.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listWatchlistHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.addWatchlistEntryHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/watchlist/:id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.updateWatchlistEntryHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.deleteWatchlistEntryHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watched",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.listWatchedHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watched",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.addWatchedEntryHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/watched/:id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.updateWatchedEntryHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:id",
+		app.requirePermission("movies:read",
+			app.requireOrganization(data.OrganizationRoleViewer, app.deleteWatchedEntryHandler)))
+
+	// Add the routes for managing two-factor authentication.
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.loadUser(app.enrollTOTPHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp/confirm",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.loadUser(app.confirmTOTPHandler))))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp",
+		app.requireUnrestrictedCredential(
+			app.requireActivatedUser(app.loadUser(app.disableTOTPHandler))))
+
+	// Add the routes for the admin users API. All of these require the
+	// "admin:users" permission.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users",
+		app.requirePermission("admin:users", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id",
+		app.requirePermission("admin:users", app.showUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions",
+		app.requirePermission("admin:users", app.showUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions",
+		app.requirePermission("admin:users", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code",
+		app.requirePermission("admin:users", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles",
+		app.requirePermission("admin:users", app.grantUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role",
+		app.requirePermission("admin:users", app.revokeUserRoleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated",
+		app.requirePermission("admin:users", app.updateUserActivationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens",
+		app.requirePermission("admin:users", app.revokeUserTokensHandler))
+
+	// Add the routes for managing invitations, which also require the
+	// "admin:users" permission.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/invitations",
+		app.requirePermission("admin:users", app.listInvitationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/invitations",
+		app.requirePermission("admin:users", app.createInvitationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/invitations/:id",
+		app.requirePermission("admin:users", app.deleteInvitationHandler))
 
 	// Add the route for the POST /v1/tokens/authentication endpoint.
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication",
 		app.createAuthenticationTokenHandler)
+	// Add the POST /v1/tokens/mfa endpoint, which completes a login for users
+	// with two-factor authentication enabled.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa",
+		app.createMFAAuthenticationTokenHandler)
+
+	// Add the routes for logging in with an external OpenID Connect provider.
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/callback",
+		app.oidcCallbackHandler)
+
+	// Add the POST /v1/tokens/refresh endpoint.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh",
+		app.refreshAuthenticationTokenHandler)
+
+	// Add the DELETE /v1/tokens/authentication endpoint, which revokes the
+	// token used to authenticate the request, and the
+	// DELETE /v1/tokens/authentication/all endpoint, which revokes every
+	// authentication token for the user.
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication",
+		app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all",
+		app.requireUnrestrictedCredential(app.deleteAllAuthenticationTokensHandler))
+
+	// Add the POST /v1/tokens/activation endpoint.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation",
+		app.createActivationTokenHandler)
+	// Add the POST /v1/tokens/password-reset endpoint.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset",
+		app.createPasswordResetTokenHandler)
 
 	// Return the httprouter instance.
 	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+)
+
+// The newTestDB() helper connects to the PostgreSQL database given by the
+// GREENLIGHT_TEST_DB_DSN environment variable, and applies all of the up
+// migrations in a new schema which is dropped again when the test finishes.
+// If the variable isn't set, then the test is skipped.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := fmt.Sprintf("greenlight_test_%d", time.Now().UnixNano())
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		defer admin.Close()
+
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Keep public on the search path, so that extensions like citext which
+	// are installed there can still be found.
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema+",public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, migration := range migrations {
+		script, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	return db
+}
+
+// The withSearchPath() helper adds a search_path run-time parameter to a DSN,
+// in either the URL or the key=value format.
+func withSearchPath(dsn, searchPath string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + searchPath
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+
+	q := u.Query()
+	q.Set("search_path", searchPath)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// The newTestApplication() helper returns an application which uses the given
+// database, with its logs discarded.
+func newTestApplication(db *sql.DB) *application {
+	var cfg config
+
+	cfg.tokens.authenticationTTL = time.Hour
+
+	return &application{
+		config: cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewModels(db),
+	}
+}
+
+// A testUser is an activated user with the editor role, and an
+// authentication token for them. Each test user is the admin of an
+// organization of their own.
+type testUser struct {
+	*data.User
+	token        string
+	organization *data.Organization
+}
+
+func insertTestUser(t *testing.T, app *application, name string) *testUser {
+	t.Helper()
+
+	user := &data.User{
+		Name:      name,
+		Email:     strings.ToLower(name) + "@example.com",
+		Activated: true,
+	}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	organization := &data.Organization{Name: name + "'s organization"}
+
+	err = app.models.Organizations.Insert(organization, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testUser{User: user, token: token.Plaintext, organization: organization}
+}
+
+// The request() helper sends a request to the handler as the given user, and
+// returns the response's status code and body.
+func request(t *testing.T, h http.Handler, user *testUser, method, path, body string) (int, []byte) {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+user.token)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, bytes.TrimSpace(b)
+}
@@ -7,16 +7,21 @@ import (
 
 	"github.com/kjloveless/greenlight/internal/data"
 	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	// Parse the email and password from the request body.
+	// Parse the email and password from the request body, along with the
+	// optional permissions and lifetime for a scoped token.
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email       string   `json:"email"`
+		Password    string   `json:"password"`
+		Permissions []string `json:"permissions"`
+		TTL         string   `json:"ttl"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -31,25 +36,34 @@ func (app *application) createAuthenticationTokenHandler(
 	data.ValidateEmail(v, input.Email)
 	data.ValidatePasswordPlaintext(v, input.Password)
 
+	options := app.readSessionOptions(v, input.Permissions, input.TTL)
+
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a
-	// 401 Unauthorized repsosne to the client.
+	// found, then we call the app.unknownAccountResponse() helper, which sends a
+	// 401 Unauthorized response to the client, or the same lockout response as
+	// a real account after too many attempts.
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.unknownAccountResponse(w, r, input.Email, input.Password)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// If the account has been locked because of too many failed login
+	// attempts, then refuse the request without even checking the password.
+	if !app.checkAccountLockout(w, r, user) {
+		return
+	}
+
 	// Check if the provided password matches the actual password for the user.
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
@@ -57,26 +71,820 @@ func (app *application) createAuthenticationTokenHandler(
 		return
 	}
 
-	// If the passwords don't match, then we call the
+	// If the passwords don't match, then we record the failed attempt, call the
 	// app.invalidCredentialsResponse() helper again and return.
 	if !match {
+		err = app.recordLoginFailure(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a
-	// 24-hour expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour,
-		data.ScopeAuthentication)
+	// Refuse to log in to an account which is scheduled for deletion. This is
+	// only checked once the password is known to be correct, so that it doesn't
+	// reveal anything about the account to someone who doesn't own it.
+	if !app.checkAccountDeletion(w, r, user) {
+		return
+	}
+
+	// If the password hash was upgraded to the current hashing algorithm or
+	// parameters, then save it. An edit conflict here just means the user was
+	// updated by another request in the meantime, in which case we'll upgrade
+	// the hash next time instead.
+	if user.Password.Rehashed() {
+		err = app.models.Users.Update(user)
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The password was correct, so clear any record of failed attempts. Note
+	// that if two-factor authentication is enabled, failed codes will still
+	// count towards locking the account.
+	err = app.models.LoginFailures.Reset(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201
+	app.completeLogin(w, r, user, options)
+}
+
+// The sessionOptions struct holds the optional restrictions that a client can
+// ask for when logging in. If permissions is not nil, then the session is
+// scoped to the intersection of those permissions with the ones that the user
+// actually holds. If ttl is non-zero, then the authentication token lasts for
+// that long instead of the usual lifetime, and no refresh token is issued, so
+// that the token can't outlive the requested lifetime.
+type sessionOptions struct {
+	permissions data.Permissions
+	ttl         time.Duration
+}
+
+// The readSessionOptions() helper validates the optional permissions and ttl
+// fields sent when logging in, adding any problems to the validator.
+func (app *application) readSessionOptions(
+	v *validator.Validator,
+	permissions []string,
+	ttl string,
+) sessionOptions {
+	var options sessionOptions
+
+	if permissions != nil {
+		v.Check(len(permissions) >= 1, "permissions", "must contain at least 1 permission")
+		v.Check(validator.Unique(permissions), "permissions", "must not contain duplicate values")
+
+		options.permissions = permissions
+	}
+
+	if ttl != "" {
+		// Signed access tokens can't outlive their revocations, so they have a
+		// shorter maximum lifetime.
+		maxTTL := app.config.tokens.refreshTTL
+		if app.jwt != nil {
+			maxTTL = min(maxTTL, data.RevocationRetention)
+		}
+
+		d, err := time.ParseDuration(ttl)
+
+		switch {
+		case err != nil:
+			v.AddError("ttl", `must be a duration such as "30m" or "2h"`)
+		case d < time.Minute:
+			v.AddError("ttl", "must be at least 1m")
+		case d > maxTTL:
+			v.AddError("ttl", "must not be more than "+maxTTL.String())
+		default:
+			options.ttl = d
+		}
+	}
+
+	return options
+}
+
+// The restrictSession() helper narrows the permissions requested for a scoped
+// session down to the ones that the user actually holds. If they hold none of
+// them, then a failed validation response is sent and false is returned.
+func (app *application) restrictSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	userID int64,
+	options *sessionOptions,
+) bool {
+	if options.permissions == nil {
+		return true
+	}
+
+	held, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	permissions := data.Permissions{}
+
+	for _, code := range options.permissions {
+		if held.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	if len(permissions) == 0 {
+		v := validator.New()
+		v.AddError("permissions", "must include at least 1 permission that you hold")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	options.permissions = permissions
+
+	return true
+}
+
+// The completeLogin() helper finishes logging in a user whose first factor
+// (their password, or an external identity provider) has been checked. If the
+// user has two-factor authentication enabled, then that isn't enough: instead
+// of an authentication token, we issue a short-lived 'mfa' token which the
+// client must exchange, along with a valid code, at the POST /v1/tokens/mfa
+// endpoint. The session options aren't stored with the mfa token, so the
+// client has to send them again at that endpoint.
+func (app *application) completeLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	user *data.User,
+	options sessionOptions,
+) {
+	if !app.restrictSession(w, r, user.ID, &options) {
+		return
+	}
+
+	enabled, err := app.models.TOTP.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enabled {
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeMFA)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Otherwise, we issue a new authentication and refresh token pair for the
+	// user.
+	env, err := app.createSessionTokens(r, user.ID, "", options)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Encode the tokens to JSON and send them in the response along with a 201
 	// Created status code.
-	err = app.writeJSON(w, http.StatusCreated,
-		envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Exchange an 'mfa' token, along with either a code from the user's
+// authenticator app or one of their recovery codes, for an authentication and
+// refresh token pair.
+func (app *application) createMFAAuthenticationTokenHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var input struct {
+		MFAToken     string   `json:"mfa_token"`
+		Code         string   `json:"code"`
+		RecoveryCode string   `json:"recovery_code"`
+		Permissions  []string `json:"permissions"`
+		TTL          string   `json:"ttl"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MFAToken != "", "mfa_token", "must be provided")
+	v.Check(len(input.MFAToken) == 26, "mfa_token", "must be 26 bytes long")
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code",
+		"a code or recovery_code must be provided")
+	v.Check(input.Code == "" || input.RecoveryCode == "", "code",
+		"must not be provided together with recovery_code")
+
+	options := app.readSessionOptions(v, input.Permissions, input.TTL)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa_token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Codes are checked against the same account lockout as passwords, to
+	// stop them being brute-forced.
+	if !app.checkAccountLockout(w, r, user) {
+		return
+	}
+
+	var ok bool
+
+	if input.RecoveryCode != "" {
+		ok, err = app.models.TOTP.UseRecoveryCode(user.ID, input.RecoveryCode)
+	} else {
+		var t *data.TOTP
+
+		t, err = app.models.TOTP.GetForUser(user.ID)
+		if err == nil {
+			ok, err = app.models.TOTP.Verify(t, input.Code)
+		}
+	}
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.LoginFailures.Reset(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The mfa token is single-use, so delete it now that it has been
+	// exchanged.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFA, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.restrictSession(w, r, user.ID, &options) {
+		return
+	}
+
+	env, err := app.createSessionTokens(r, user.ID, "", options)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The checkAccountLockout() helper sends an accountLockedResponse() and
+// returns false if the user's account is currently locked.
+func (app *application) checkAccountLockout(
+	w http.ResponseWriter,
+	r *http.Request,
+	user *data.User,
+) bool {
+	lockedUntil, err := app.models.LoginFailures.GetLockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if lockedUntil != nil {
+		app.accountLockedResponse(w, r, *lockedUntil)
+		return false
+	}
+
+	return true
+}
+
+// The unknownAccountResponse() helper responds to a login attempt for an email
+// address which doesn't belong to any account. Failed attempts for unknown
+// addresses are counted and locked in exactly the same way as for real
+// accounts, and the password is still hashed, so that neither the lockout
+// response nor the response time reveals which addresses have accounts.
+func (app *application) unknownAccountResponse(w http.ResponseWriter, r *http.Request, email, password string) {
+	lockedUntil, err := app.models.LoginFailures.GetLockedUntilForEmail(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if lockedUntil != nil {
+		app.accountLockedResponse(w, r, *lockedUntil)
+		return
+	}
+
+	// Check the password against a dummy hash, just as it would be checked
+	// against the account's real one, so that the response takes the same
+	// amount of time either way.
+	err = data.VerifyDummyPassword(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.LoginFailures.RecordFailureForEmail(email, app.config.lockout)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// The recordLoginFailure() helper records a failed login attempt for a user.
+// If this causes their account to be locked, then we email them to let them
+// know, since it may mean that someone is trying to break in.
+func (app *application) recordLoginFailure(user *data.User) error {
+	lockedUntil, err := app.models.LoginFailures.RecordFailure(user.ID,
+		app.config.lockout)
+	if err != nil {
+		return err
+	}
+
+	if lockedUntil != nil {
+		app.logger.Warn("user account locked", "user_id", user.ID,
+			"locked_until", lockedUntil.Format(time.RFC3339))
+
+		app.background(func() {
+			data := map[string]any{
+				"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			}
+
+			err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	return nil
+}
+
+// pruneUnknownLoginFailures() runs in a background goroutine, deleting the
+// failed login records for unknown email addresses once they are too old to
+// count. Otherwise anyone could fill the table up by trying to log in with
+// made-up addresses.
+func (app *application) pruneUnknownLoginFailures() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := app.models.LoginFailures.DeleteStaleForEmails()
+		if err != nil {
+			app.logger.Error(err.Error())
+			continue
+		}
+
+		if count > 0 {
+			app.logger.Info("pruned unknown login failures", "count", count)
+		}
+	}
+}
+
+// The matchCurrentPassword() helper checks the password that a logged-in user
+// has given to re-confirm a sensitive change. Wrong passwords count towards
+// the account lockout just like failed logins, so that someone holding a
+// stolen token can't use it to guess the password, and a locked account is
+// refused without checking the password at all. If it returns false, then a
+// response has already been sent; for a wrong password, this is done by
+// calling mismatch.
+func (app *application) matchCurrentPassword(
+	w http.ResponseWriter,
+	r *http.Request,
+	user *data.User,
+	password string,
+	mismatch func(),
+) bool {
+	if !app.checkAccountLockout(w, r, user) {
+		return false
+	}
+
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !match {
+		err = app.recordLoginFailure(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+
+		mismatch()
+		return false
+	}
+
+	err = app.models.LoginFailures.Reset(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	return true
+}
+
+// The createSessionTokens() helper issues a new authentication token and
+// refresh token for a user, using the lifetimes from the application config,
+// and returns them in an envelope ready to be sent to the client. The family
+// parameter should be the empty string when logging in, or the family of the
+// refresh token being exchanged when refreshing. The permissions in the
+// options must already have been narrowed down with restrictSession().
+func (app *application) createSessionTokens(
+	r *http.Request,
+	userID int64,
+	family string,
+	options sessionOptions,
+) (envelope, error) {
+	accessTTL := app.config.tokens.authenticationTTL
+	refreshTTL := app.config.tokens.refreshTTL
+
+	if options.ttl > 0 {
+		accessTTL = options.ttl
+		refreshTTL = 0
+	}
+
+	// We record the client's IP address and User-Agent, so that the user can
+	// recognize the session later.
+	access, refresh, err := app.models.Tokens.NewPair(userID, accessTTL,
+		refreshTTL, family, realip.FromRequest(r), r.UserAgent(),
+		options.permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	// If signed access tokens are enabled, then send one of those instead of
+	// the opaque authentication token.
+	if app.jwt != nil {
+		err = app.signAccessToken(access)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	env := envelope{"authentication_token": access}
+
+	if refresh != nil {
+		env["refresh_token"] = refresh
+	}
+
+	return env, nil
+}
+
+// Exchange a refresh token for a new authentication and refresh token pair.
+// Refresh tokens are rotated on every use: the one presented here can never be
+// used again, and if it is, the whole token family is revoked.
+func (app *application) refreshAuthenticationTokenHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.RefreshToken != "", "refresh_token", "must be provided")
+	v.Check(len(input.RefreshToken) == 26, "refresh_token", "must be 26 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.UseRefreshToken(input.RefreshToken)
+	if err != nil {
+		switch {
+		// If the refresh token has been used before, it has probably been stolen.
+		// Log this so that it can be investigated. The family has already been
+		// deleted, but any signed access tokens issued to it are still valid
+		// until they're revoked too.
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.Warn("refresh token reused, token family revoked",
+				"ip", realip.FromRequest(r))
+
+			err = app.revokeSessionTokens(token.UserID, 0, token.Family)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			v.AddError("refresh_token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("refresh_token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the refresh token belongs to a scoped session, then the new tokens are
+	// restricted to the same permissions.
+	var options sessionOptions
+
+	permissions, scoped, err := app.models.Permissions.GetAllForToken(input.RefreshToken)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if scoped {
+		options.permissions = permissions
+	}
+
+	env, err := app.createSessionTokens(r, token.UserID, token.Family, options)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Generate a password reset token and send it to the user's email address.
+// To avoid leaking which email addresses are registered, we always send the
+// same 202 Accepted response, and only actually send an email when the address
+// belongs to an activated user account.
+func (app *application) createPasswordResetTokenHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Try to retrieve the corresponding user record for the email address. A
+	// missing record isn't reported to the client.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only issue a token if the user exists and has been activated.
+	if err == nil && user.Activated {
+		// Create a new password reset token with a 45-minute expiry
+		// time.
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute,
+			data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Email the user with their password reset token.
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			// Since email addresses MAY be case sensitive, notice that we are
+			// sending this email using the address stored in our database for the
+			// user --- not to the input.Email address provided by the client in
+			// this request.
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	// Send a 202 Accepted response and confirmation message to the client.
+	env := envelope{"message": "if an activated account with that email address exists, an email will be sent to it containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Issue a fresh activation token and resend it to the user. Just like the
+// password reset handler, we always send the same 202 Accepted response so that
+// the endpoint can't be used to discover which email addresses are registered.
+// Accounts which are already activated are silently refused.
+func (app *application) createActivationTokenHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Try to retrieve the corresponding user record for the email address. A
+	// missing record isn't reported to the client.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only issue a token if the user exists and hasn't been activated yet.
+	if err == nil && !user.Activated {
+		// Delete any existing activation tokens for the user, so that only the
+		// most recently sent token can be used.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Create a new activation token with the same 3-day expiry as the one
+		// sent by registerUserHandler.
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour,
+			data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Email the user with their additional activation token.
+		app.background(func() {
+			data := map[string]any{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	// Send a 202 Accepted response and confirmation message to the client.
+	env := envelope{"message": "if an unactivated account with that email address exists, an email will be sent to it containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke the authentication token that was used to authenticate the current
+// request (i.e. log out).
+func (app *application) deleteAuthenticationTokenHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	// If the request was authenticated with a signed access token, then we
+	// revoke its session, and add the token to the denylist.
+	if claims, ok := app.contextGetClaims(r); ok {
+		family, err := app.models.Tokens.DeleteSessionForUser(claims.ID, claims.Subject)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// If the session has already been deleted, fall back to the family in
+		// the token's claims.
+		if family == "" {
+			family = claims.Family
+		}
+
+		err = app.revokeSessionTokens(claims.Subject, claims.ID, family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK,
+			envelope{"message": "authentication token successfully revoked"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Retrieve the plaintext token that the authenticate() middleware stored
+	// in the request context. If the request was authenticated some other way
+	// (e.g. with an API key) then there is no token to revoke.
+	token := app.contextGetToken(r)
+	if token == "" {
+		app.badRequestResponse(w, r,
+			errors.New("request was not authenticated with an authentication token"))
+		return
+	}
+
+	// Delete the token from the database, along with the refresh token that
+	// was issued alongside it.
+	err := app.models.Tokens.Delete(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		// If the token was deleted by another request in the meantime, treat it
+		// as an invalid token.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke every authentication and refresh token belonging to the current user
+// (i.e. log out everywhere).
+func (app *application) deleteAllAuthenticationTokensHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "all authentication tokens successfully revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/totp"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// Start enrolling the current user in two-factor authentication. This
+// generates a new secret and returns it, along with an otpauth:// URI which can
+// be shown as a QR code. Two-factor authentication isn't actually enabled
+// until the user confirms that they've set up their authenticator app. Like
+// disabling it, enrolling requires the user to re-confirm their password, so
+// that someone holding a stolen token can't enable two-factor authentication
+// with their own secret and lock the real owner out.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	mismatch := func() { app.invalidCredentialsResponse(w, r) }
+
+	if !app.matchCurrentPassword(w, r, user, input.Password, mismatch) {
+		return
+	}
+
+	secret := totp.GenerateSecret()
+
+	err = app.models.TOTP.Enroll(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"totp": map[string]string{
+		"secret": secret,
+		"uri":    totp.URI("Greenlight", user.Email, secret),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Confirm two-factor authentication enrollment with a code from the user's
+// authenticator app. If the code is valid, two-factor authentication is
+// enabled and a set of one-time recovery codes is returned. These are only
+// ever shown once.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	t, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if t.Enabled {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.models.TOTP.Verify(t, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.TOTP.Enable(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Disable two-factor authentication for the current user. The user must
+// re-confirm their password to do this.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	mismatch := func() { app.invalidCredentialsResponse(w, r) }
+
+	if !app.matchCurrentPassword(w, r, user, input.Password, mismatch) {
+		return
+	}
+
+	err = app.models.TOTP.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -13,9 +13,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// Create an anonymous struct to hold the expected data from the request
 	// body.
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name            string `json:"name"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		InvitationToken string `json:"invitation_token"`
 	}
 
 	// Parse the request body into the anonymous struct.
@@ -25,15 +26,25 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	v := validator.New()
+
+	// Depending on the registration mode, an invitation may be needed to
+	// register. Someone who registers with an invitation doesn't need to
+	// activate their account, since the invitation was sent to their email
+	// address.
+	invitation, ok := app.readInvitation(w, r, v, input.Email,
+		input.InvitationToken)
+	if !ok {
+		return
+	}
+
 	// Copy the data from the request body into a new User struct. Notice also
-	// that we set the Activated field to false, which isn't strictly necessary
-	// because the Activated field will have the zero-value of false by default.
-	// But setting this explicitly helps to make our intention clear to anyone
-	// reading the code.
+	// that the Activated field is only true if the user was invited; everyone
+	// else has to activate their account with the token that we email them.
 	user := &data.User{
 		Name:      input.Name,
 		Email:     input.Email,
-		Activated: false,
+		Activated: invitation != nil,
 	}
 
 	// Use the Password.Set() method to generate and store the hashed and
@@ -44,8 +55,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	v := validator.New()
-
 	// Validate the user struct and return the error messages to the client if
 	// any of the checks fail.
 	if data.ValidateUser(v, user); !v.Valid() {
@@ -70,8 +79,26 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	// If the user was invited, then give them the role and permissions from
+	// their invitation, and delete the invitation so that it can't be used
+	// again.
+	if invitation != nil {
+		err = app.acceptInvitation(user, invitation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Give the new user the default role, which decides the permissions that
+	// they start out with.
+	err = app.models.Roles.AddForUser(user.ID, app.config.defaultRole)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -179,3 +206,378 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Verify the password reset token and set a new password for the user.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's new password and password reset token.
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the details of the user associated with the password reset
+	// token, returning an error message if no matching record was found.
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset,
+		input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Now that we know who the user is, check that the new password is strong
+	// enough.
+	if data.ValidatePasswordStrength(v, input.Password, user.Email, user.Name); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Set the new password for the user.
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Save the updated user record in our database, checking for any edit
+	// conflicts as normal.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If everything was successful, then delete all password reset tokens for
+	// the user so that the token can't be used again.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Also delete the user's existing authentication and refresh tokens, so
+	// that anyone who logged in with the old password is signed out.
+	err = app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.revokeUserTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the user a confirmation message.
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update the current user's name and/or password. Changing the password
+// requires the user's current password too, so that someone who gets hold of
+// an authentication token can't lock the real owner out of their account.
+// Once the password has been changed, all of the user's sessions are signed
+// out, including the one used to make this request.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name            *string `json:"name"`
+		Password        *string `json:"password"`
+		CurrentPassword *string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Password != nil {
+		// Check the current password before doing anything else.
+		if input.CurrentPassword == nil || *input.CurrentPassword == "" {
+			v.AddError("current_password", "must be provided to change your password")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		mismatch := func() {
+			v.AddError("current_password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+		}
+
+		if !app.matchCurrentPassword(w, r, user, *input.CurrentPassword, mismatch) {
+			return
+		}
+
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Validating the user also checks the strength of the new password, if
+	// one was provided.
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Just like after a password reset, sign out everyone who logged in with
+	// the old password.
+	if input.Password != nil {
+		err = app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.revokeUserTokens(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Start changing the current user's email address. The change isn't made
+// straight away; instead we send a confirmation token to the new address, and
+// only swap the address over once the token has been used.
+func (app *application) createEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Just like changing the password, changing the email address requires
+	// the user to re-confirm their current password.
+	mismatch := func() {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+	}
+
+	if !app.matchCurrentPassword(w, r, user, input.Password, mismatch) {
+		return
+	}
+
+	// Check that the new address isn't already in use. This is checked again
+	// when the change is confirmed, since another account could register the
+	// address in the meantime.
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Delete any previous email change tokens, so that only the latest request
+	// can be confirmed, and then record the pending change.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.EmailChanges.Set(user.ID, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour,
+		data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the confirmation token to the *new* address, which proves that the
+	// user actually owns it.
+	app.background(func() {
+		data := map[string]any{
+			"emailChangeToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(input.Email, "token_email_change.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing instructions to confirm the change"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Confirm a pending email address change using the token that was sent to the
+// new address, and let the old address know that the change has been made.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange,
+		input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	newEmail, err := app.models.EmailChanges.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = newEmail
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The change has been made, so clean up the token and pending change.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.EmailChanges.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Let the old address know about the change, in case it wasn't made by
+	// the account owner.
+	app.background(func() {
+		data := map[string]any{
+			"newEmail": newEmail,
+		}
+
+		err := app.mailer.Send(oldEmail, "email_changed.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
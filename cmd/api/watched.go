@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// List the movies in the current user's watched history from the active
+// organization's catalog, with pagination and sorting.
+func (app *application) listWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-watched_on")
+	input.Filters.SortSafeList = []string{
+		"watched_on", "title", "year",
+		"-watched_on", "-title", "-year",
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Watched.GetAllForUser(
+		app.contextGetUser(r).ID,
+		app.contextGetMembership(r).OrganizationID,
+		input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"watched": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Record that the current user has watched a movie from the active
+// organization's catalog. If no watched_on date is given, then today's date is
+// used.
+func (app *application) addWatchedEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID   int64      `json:"movie_id"`
+		Notes     string     `json:"notes"`
+		WatchedOn *data.Date `json:"watched_on"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	movie, ok := app.readListMovie(w, r, v, input.MovieID)
+	if !ok {
+		return
+	}
+
+	entry := &data.WatchedEntry{
+		UserID:    app.contextGetUser(r).ID,
+		Movie:     movie,
+		Notes:     input.Notes,
+		WatchedOn: data.Date{Time: time.Now().UTC()},
+	}
+
+	if input.WatchedOn != nil {
+		entry.WatchedOn = *input.WatchedOn
+	}
+
+	if data.ValidateWatchedEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watched.Insert(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateListEntry):
+			v.AddError("movie_id", "this movie is already in your watched history")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Change the notes or watched date of a watched history entry. The entry is
+// identified by the ID of the movie.
+func (app *application) updateWatchedEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovie(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := app.models.Watched.Get(app.contextGetUser(r).ID, movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Notes     *string    `json:"notes"`
+		WatchedOn *data.Date `json:"watched_on"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Notes != nil {
+		entry.Notes = *input.Notes
+	}
+
+	if input.WatchedOn != nil {
+		entry.WatchedOn = *input.WatchedOn
+	}
+
+	v := validator.New()
+
+	if data.ValidateWatchedEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watched.Update(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Remove a movie from the current user's watched history.
+func (app *application) deleteWatchedEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovie(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Watched.Delete(app.contextGetUser(r).ID, movie.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "movie successfully removed from watched history"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
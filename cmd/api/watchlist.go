@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// List the movies on the current user's watchlist from the active
+// organization's catalog, with pagination and sorting.
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-added_at")
+	input.Filters.SortSafeList = []string{
+		"added_at", "title", "year",
+		"-added_at", "-title", "-year",
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Watchlist.GetAllForUser(
+		app.contextGetUser(r).ID,
+		app.contextGetMembership(r).OrganizationID,
+		input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"watchlist": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a movie from the active organization's catalog to the current user's
+// watchlist.
+func (app *application) addWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID int64  `json:"movie_id"`
+		Notes   string `json:"notes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	movie, ok := app.readListMovie(w, r, v, input.MovieID)
+	if !ok {
+		return
+	}
+
+	entry := &data.WatchlistEntry{
+		UserID: app.contextGetUser(r).ID,
+		Movie:  movie,
+		Notes:  input.Notes,
+	}
+
+	if data.ValidateListNotes(v, entry.Notes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watchlist.Insert(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateListEntry):
+			v.AddError("movie_id", "this movie is already on your watchlist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Change the notes on a watchlist entry. The entry is identified by the ID of
+// the movie.
+func (app *application) updateWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovie(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := app.models.Watchlist.Get(app.contextGetUser(r).ID, movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Notes *string `json:"notes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Notes != nil {
+		entry.Notes = *input.Notes
+	}
+
+	v := validator.New()
+
+	if data.ValidateListNotes(v, entry.Notes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watchlist.Update(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Remove a movie from the current user's watchlist.
+func (app *application) deleteWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovie(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Watchlist.Delete(app.contextGetUser(r).ID, movie.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK,
+		envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readListMovie() helper fetches the movie that a user is adding to one of
+// their lists from the active organization's catalog. Unlike readMovie(), the
+// movie ID comes from the request body, so a missing movie is reported as a
+// validation error.
+func (app *application) readListMovie(
+	w http.ResponseWriter,
+	r *http.Request,
+	v *validator.Validator,
+	movieID int64,
+) (*data.Movie, bool) {
+	if v.Check(movieID > 0, "movie_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(movieID, app.contextGetMembership(r).OrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "no matching movie found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
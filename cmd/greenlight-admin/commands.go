@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// newFlagSet() returns a flag set for a command. Errors are returned rather
+// than exiting, so that they are reported as JSON like any other error.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("greenlight-admin "+name, flag.ContinueOnError)
+}
+
+// readPassword() returns the password flag value. If it is "-" the password is
+// read from the first line of stdin instead, which keeps it out of the process
+// list and shell history.
+func (app *application) readPassword(password string) (string, error) {
+	if password != "-" {
+		return password, nil
+	}
+
+	scanner := bufio.NewScanner(app.stdin)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("no password on stdin")
+	}
+
+	return strings.TrimRight(scanner.Text(), "\r"), nil
+}
+
+// getUser() looks up a user by email address.
+func (app *application) getUser(email string) (*data.User, error) {
+	if email == "" {
+		return nil, errors.New("-email must be provided")
+	}
+
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, fmt.Errorf("no user with email address %q", email)
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// splitList() splits a comma-separated flag value, ignoring empty entries.
+func splitList(s string) []string {
+	var list []string
+
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// createUser() creates a new user with the given role. Unlike registering
+// through the API, no welcome email is sent, and the account can be activated
+// straight away with -activated.
+func (app *application) createUser(args []string) error {
+	flags := newFlagSet("create-user")
+	name := flags.String("name", "", "Name")
+	email := flags.String("email", "", "Email address")
+	password := flags.String("password", "", `Password ("-" to read it from stdin)`)
+	role := flags.String("role", "viewer", "Role to give the user")
+	activated := flags.Bool("activated", false, "Activate the account")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := app.readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	user := &data.User{
+		Name:      *name,
+		Email:     *email,
+		Activated: *activated,
+	}
+
+	err = user.Password.Set(plaintext)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		return validationError{v.Errors}
+	}
+
+	_, err = app.models.Roles.Get(*role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return fmt.Errorf("no role named %q", *role)
+		default:
+			return err
+		}
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, *role)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(map[string]any{"user": user, "roles": []string{*role}})
+}
+
+// activateUser() activates a user's account without needing the activation
+// token.
+func (app *application) activateUser(args []string) error {
+	flags := newFlagSet("activate-user")
+	email := flags.String("email", "", "Email address")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getUser(*email)
+	if err != nil {
+		return err
+	}
+
+	if !user.Activated {
+		user.Activated = true
+
+		err = app.models.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		// Revoke the user's signed access tokens (if the API is issuing them),
+		// since they still say that the account isn't activated. The user gets
+		// activated tokens the next time they log in or refresh.
+		_, err = app.models.Revocations.RevokeUser(user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(map[string]any{"user": user})
+}
+
+// grant() grants permission codes and/or roles to a user.
+func (app *application) grant(args []string) error {
+	return app.changeAccess("grant", args, true)
+}
+
+// revoke() revokes directly granted permission codes and/or roles from a user.
+func (app *application) revoke(args []string) error {
+	return app.changeAccess("revoke", args, false)
+}
+
+func (app *application) changeAccess(name string, args []string, grant bool) error {
+	flags := newFlagSet(name)
+	email := flags.String("email", "", "Email address")
+	permissions := flags.String("permissions", "", "Comma-separated permission codes")
+	roles := flags.String("roles", "", "Comma-separated role names")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	codes := splitList(*permissions)
+	names := splitList(*roles)
+
+	if len(codes) == 0 && len(names) == 0 {
+		return errors.New("-permissions or -roles must be provided")
+	}
+
+	user, err := app.getUser(*email)
+	if err != nil {
+		return err
+	}
+
+	// Check that every permission code and role exists, so that typos aren't
+	// silently ignored.
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		if !all.Include(code) {
+			return fmt.Errorf("no permission with code %q", code)
+		}
+	}
+
+	for _, role := range names {
+		_, err := app.models.Roles.Get(role)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return fmt.Errorf("no role named %q", role)
+			default:
+				return err
+			}
+		}
+	}
+
+	if grant {
+		if len(codes) > 0 {
+			err = app.models.Permissions.AddForUser(user.ID, codes...)
+		}
+		if err == nil && len(names) > 0 {
+			err = app.models.Roles.AddForUser(user.ID, names...)
+		}
+	} else {
+		if len(codes) > 0 {
+			err = app.models.Permissions.RemoveForUser(user.ID, codes...)
+		}
+		if err == nil && len(names) > 0 {
+			err = app.models.Roles.RemoveForUser(user.ID, names...)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Revoke the user's signed access tokens (if the API is issuing them) so
+	// that the change takes effect straight away.
+	_, err = app.models.Revocations.RevokeUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	userRoles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	userPermissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(map[string]any{
+		"user":        user,
+		"roles":       userRoles,
+		"permissions": userPermissions,
+	})
+}
+
+// resetPassword() sets a new password for a user. Just like resetting a
+// password through the API, this logs the user out everywhere and clears any
+// account lockout.
+func (app *application) resetPassword(args []string) error {
+	flags := newFlagSet("reset-password")
+	email := flags.String("email", "", "Email address")
+	password := flags.String("password", "", `New password ("-" to read it from stdin)`)
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getUser(*email)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := app.readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	err = user.Password.Set(plaintext)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		return validationError{v.Errors}
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.models.Revocations.RevokeUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.LoginFailures.Reset(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(map[string]any{"user": user})
+}
+
+// purgeTokens() deletes all expired tokens, along with any revocations of
+// signed access tokens which have expired and any stale failed login records
+// for unknown email addresses.
+func (app *application) purgeTokens(args []string) error {
+	flags := newFlagSet("purge-tokens")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	count, err := app.models.Tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	revocations, err := app.models.Revocations.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	loginFailures, err := app.models.LoginFailures.DeleteStaleForEmails()
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(map[string]any{
+		"deleted":                count,
+		"deleted_revocations":    revocations,
+		"deleted_login_failures": loginFailures,
+	})
+}
+
+// dbStats() prints the size of the database and the number of rows in each of
+// the main tables.
+func (app *application) dbStats(args []string) error {
+	flags := newFlagSet("db-stats")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var size int64
+
+	err = app.db.QueryRowContext(ctx,
+		"SELECT pg_database_size(current_database())").Scan(&size)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{"users", "movies", "tokens", "api_keys", "permissions", "roles"}
+	counts := make(map[string]int64, len(tables))
+
+	for _, table := range tables {
+		var count int64
+
+		// The table names come from the fixed list above, so it's safe to
+		// interpolate them into the query.
+		err := app.db.QueryRowContext(ctx,
+			fmt.Sprintf("SELECT count(*) FROM %s", table)).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		counts[table] = count
+	}
+
+	var expired int64
+
+	err = app.db.QueryRowContext(ctx,
+		"SELECT count(*) FROM tokens WHERE expiry < $1", time.Now()).Scan(&expired)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(map[string]any{
+		"database_size_bytes": size,
+		"rows":                counts,
+		"expired_tokens":      expired,
+		"pool":                app.db.Stats(),
+	})
+}
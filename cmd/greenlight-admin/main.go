@@ -0,0 +1,185 @@
+// The greenlight-admin command is a CLI for operators. It talks directly to the
+// database through data.Models, so it can be used to manage users without the
+// API server running (or without going through psql by hand).
+//
+// Usage:
+//
+//	greenlight-admin [-db-dsn=DSN] <command> [flags]
+//
+// Every command writes a single JSON object to stdout on success. On failure a
+// JSON object with an "error" key (and "errors" for validation failures) is
+// written to stderr and the exit status is 1, which makes the output easy to
+// use from scripts.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/data"
+
+	// Import the pq driver so that it can register itself with the database/sql
+	// package.
+	_ "github.com/lib/pq"
+)
+
+// The application struct holds the dependencies for our commands.
+type application struct {
+	db     *sql.DB
+	models data.Models
+	stdout io.Writer
+	stdin  io.Reader
+}
+
+// A command runs with the arguments that follow its name on the command line.
+type command struct {
+	description string
+	run         func(app *application, args []string) error
+}
+
+var commands = map[string]command{
+	"create-user":    {"Create a new user", (*application).createUser},
+	"activate-user":  {"Activate a user's account", (*application).activateUser},
+	"grant":          {"Grant permissions or roles to a user", (*application).grant},
+	"revoke":         {"Revoke permissions or roles from a user", (*application).revoke},
+	"reset-password": {"Set a new password for a user", (*application).resetPassword},
+	"purge-tokens":   {"Delete all expired tokens and stale login failures", (*application).purgeTokens},
+	"db-stats":       {"Print database statistics", (*application).dbStats},
+}
+
+func main() {
+	flags := flag.NewFlagSet("greenlight-admin", flag.ContinueOnError)
+	dsn := flags.String("db-dsn", os.Getenv("GREENLIGHT_DB_DSN"),
+		"PostgreSQL DSN (defaults to $GREENLIGHT_DB_DSN)")
+	flags.Usage = func() { usage(flags) }
+
+	err := flags.Parse(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	if flags.NArg() < 1 {
+		usage(flags)
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fail(fmt.Errorf("unknown command %q", flags.Arg(0)))
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+
+	app := &application{
+		db:     db,
+		models: data.NewModels(db),
+		stdout: os.Stdout,
+		stdin:  os.Stdin,
+	}
+
+	// Note that os.Exit() doesn't run deferred functions, so we close the
+	// connection pool ourselves before exiting. If the command was run with -h,
+	// its flag set has already printed the usage, so there's nothing to report.
+	err = cmd.run(app, flags.Args()[1:])
+	if err != nil {
+		db.Close()
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fail(err)
+	}
+}
+
+// usage() prints the global flags and the list of available commands.
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: greenlight-admin [flags] <command> [command flags]\n\nFlags:\n")
+	flags.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'greenlight-admin <command> -h' for help with a command.\n")
+}
+
+// The validationError type carries the field errors from a validator, so that
+// they can be included in the JSON output.
+type validationError struct {
+	errors map[string]string
+}
+
+func (e validationError) Error() string {
+	return "failed validation"
+}
+
+// fail() writes an error to stderr as JSON and exits with a non-zero status.
+func fail(err error) {
+	out := map[string]any{"error": err.Error()}
+
+	var verr validationError
+	if errors.As(err, &verr) {
+		out["errors"] = verr.errors
+	}
+
+	js, _ := json.MarshalIndent(out, "", "\t")
+	fmt.Fprintln(os.Stderr, string(js))
+	os.Exit(1)
+}
+
+// writeJSON() writes a JSON object to stdout.
+func (app *application) writeJSON(out map[string]any) error {
+	js, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(app.stdout, string(js))
+	return err
+}
+
+// openDB() opens and checks a connection pool. The CLI only runs one command,
+// so it doesn't need more than a couple of connections.
+func openDB(dsn string) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, errors.New("no database DSN: set -db-dsn or $GREENLIGHT_DB_DSN")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
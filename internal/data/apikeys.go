@@ -0,0 +1,339 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// All API keys start with this prefix. This lets the authenticate() middleware
+// tell them apart from regular authentication tokens when they are sent in a
+// "Bearer" Authorization header, and makes them easy to spot if they are ever
+// leaked.
+const APIKeyPrefix = "glk_"
+
+// Define an APIKey struct to hold the data for an individual API key. The
+// Plaintext field is only ever populated when the key is first created, and
+// the Permissions field holds the subset of the owner's permissions that the
+// key is allowed to use. A nil Expiry means that the key never expires.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitzero"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Version     int32       `json:"version"`
+}
+
+// IsAPIKey reports whether a plaintext credential looks like an API key
+// (rather than a regular token).
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, APIKeyPrefix)
+}
+
+// Generate a new random plaintext key for the APIKey, along with its SHA-256
+// hash.
+func (k *APIKey) generate() {
+	k.Plaintext = APIKeyPrefix + rand.Text()
+
+	hash := sha256.Sum256([]byte(k.Plaintext))
+	k.Hash = hash[:]
+}
+
+// Check that a plaintext API key has the expected prefix and is exactly 30
+// bytes long (the 4-byte prefix plus 26 random characters).
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(IsAPIKey(keyPlaintext), "key", "must be a valid API key")
+	v.Check(len(keyPlaintext) == len(APIKeyPrefix)+26, "key", "must be 30 bytes long")
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(key.Permissions != nil, "permissions", "must be provided")
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// Define the APIKeyModel type.
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert() generates a new plaintext key and adds the API key, along with its
+// permissions, to the database. Both inserts happen in a single transaction so
+// that we never end up with a key that has no permissions.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	key.generate()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+    INSERT INTO api_keys (user_id, name, hash, expiry)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, created_at, version`
+
+	args := []any{key.UserID, key.Name, key.Hash, key.Expiry}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt,
+		&key.Version)
+	if err != nil {
+		return err
+	}
+
+	query = `
+    INSERT INTO api_keys_permissions
+    SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, key.ID, pq.Array(key.Permissions))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get() retrieves a specific API key belonging to a specific user. Including
+// the user ID in the query means that users can only ever see their own keys.
+func (m APIKeyModel) Get(id, userID int64) (*APIKey, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT api_keys.id, api_keys.user_id, api_keys.created_at, api_keys.name,
+      api_keys.expiry, api_keys.last_used_at, api_keys.version,
+      array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+    FROM api_keys
+    LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+    LEFT JOIN permissions ON api_keys_permissions.permission_id = permissions.id
+    WHERE api_keys.id = $1 AND api_keys.user_id = $2
+    GROUP BY api_keys.id`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.CreatedAt,
+		&key.Name,
+		&key.Expiry,
+		&key.LastUsedAt,
+		&key.Version,
+		pq.Array(&key.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+// GetAllForUser() returns all API keys for a specific user, including expired
+// ones, newest first.
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+    SELECT api_keys.id, api_keys.user_id, api_keys.created_at, api_keys.name,
+      api_keys.expiry, api_keys.last_used_at, api_keys.version,
+      array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+    FROM api_keys
+    LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+    LEFT JOIN permissions ON api_keys_permissions.permission_id = permissions.id
+    WHERE api_keys.user_id = $1
+    GROUP BY api_keys.id
+    ORDER BY api_keys.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.CreatedAt,
+			&key.Name,
+			&key.Expiry,
+			&key.LastUsedAt,
+			&key.Version,
+			pq.Array(&key.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Update() changes the name of an API key, using the version number to
+// prevent edit conflicts. The permissions and expiry of a key are fixed once
+// it has been created.
+func (m APIKeyModel) Update(key *APIKey) error {
+	query := `
+    UPDATE api_keys
+    SET name = $1, version = version + 1
+    WHERE id = $2 AND user_id = $3 AND version = $4
+    RETURNING version`
+
+	args := []any{key.Name, key.ID, key.UserID, key.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() revokes a specific API key belonging to a specific user. The
+// api_keys_permissions rows are removed by the ON DELETE CASCADE constraint.
+func (m APIKeyModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM api_keys
+    WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUser() revokes all of a user's API keys.
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+    DELETE FROM api_keys
+    WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// GetForKey() looks up an unexpired API key by its plaintext value, returning
+// the user who owns it along with the permissions that the key carries. It
+// also records the time that the key was last used.
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*User, Permissions, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+    UPDATE api_keys
+    SET last_used_at = NOW()
+    FROM users
+    WHERE users.id = api_keys.user_id
+    AND api_keys.hash = $1
+    AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)
+    RETURNING users.id, users.created_at, users.name, users.email,
+      users.password_hash, users.activated, users.version,
+      ARRAY(
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN api_keys_permissions
+          ON api_keys_permissions.permission_id = permissions.id
+        WHERE api_keys_permissions.api_key_id = api_keys.id
+      )`
+
+	var (
+		user        User
+		permissions Permissions
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		pq.Array(&permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &user, permissions, nil
+}
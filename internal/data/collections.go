@@ -0,0 +1,413 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrMovieInCollection error, which is returned when a movie
+// is added to a collection while it already belongs to a different one.
+var ErrMovieInCollection = errors.New("movie already in a collection")
+
+// Define a Collection struct for an ordered group of movies, like a franchise
+// or a series of sequels. Like movies, collections belong to an organization's
+// catalog.
+type Collection struct {
+	ID             int64     `json:"id"`
+	OrganizationID int64     `json:"-"`
+	CreatedAt      time.Time `json:"-"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitzero"`
+	Version        int32     `json:"version"`
+}
+
+// Define a MovieCollection struct, which is embedded in a movie's JSON to show
+// the collection that it belongs to and its position in it. Positions start
+// at 1.
+type MovieCollection struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Position int32  `json:"position"`
+}
+
+// Implement the sql.Scanner interface on MovieCollection, so that the
+// collection column built by the movieCollection join can be scanned straight
+// into a movie. Movies which aren't in a collection have a NULL collection
+// column, which leaves the pointer nil.
+func (c *MovieCollection) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into MovieCollection", src)
+	}
+
+	return json.Unmarshal(b, c)
+}
+
+// The movieCollection constant is a lateral join which finds the collection
+// that each movie belongs to, if any. Rather than using the stored position,
+// which can have gaps after a movie is deleted, it counts the movies up to and
+// including this one so that the positions are always 1, 2, 3 and so on.
+const movieCollection = `
+    LEFT JOIN LATERAL (
+      SELECT json_build_object(
+        'id', collections.id,
+        'name', collections.name,
+        'position', (
+          SELECT count(*) FROM collections_movies AS previous
+          WHERE previous.collection_id = collections_movies.collection_id
+          AND previous.position <= collections_movies.position
+        )
+      ) AS collection
+      FROM collections_movies
+      INNER JOIN collections ON collections.id = collections_movies.collection_id
+      WHERE collections_movies.movie_id = movies.id
+    ) movie_collection ON true`
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(len(collection.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+}
+
+func ValidateCollectionMovies(v *validator.Validator, movieIDs []int64) {
+	v.Check(movieIDs != nil, "movie_ids", "must be provided")
+	v.Check(len(movieIDs) <= 100, "movie_ids", "must not contain more than 100 movies")
+	v.Check(validator.Unique(movieIDs), "movie_ids", "must not contain duplicate values")
+}
+
+// Define the CollectionModel type.
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new collection to an organization's catalog. If movieIDs
+// isn't nil, then those movies are added to it in the same transaction, as
+// described for setMovies().
+func (m CollectionModel) Insert(collection *Collection, movieIDs []int64) error {
+	query := `
+    INSERT INTO collections (organization_id, name, description)
+    VALUES ($1, $2, $3)
+    RETURNING id, created_at, version`
+
+	args := []any{collection.OrganizationID, collection.Name, collection.Description}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	if movieIDs != nil {
+		err = setMovies(ctx, tx, collection, movieIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get() retrieves a specific collection from an organization's catalog.
+func (m CollectionModel) Get(id, organizationID int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, organization_id, created_at, name, description, version
+    FROM collections
+    WHERE id = $1 AND organization_id = $2`
+
+	var collection Collection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, organizationID).Scan(
+		&collection.ID,
+		&collection.OrganizationID,
+		&collection.CreatedAt,
+		&collection.Name,
+		&collection.Description,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+// GetAll() returns a page of the collections in an organization's catalog,
+// optionally filtered by name.
+func (m CollectionModel) GetAll(organizationID int64, name string, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), id, organization_id, created_at, name, description,
+      version
+    FROM collections
+    WHERE organization_id = $1
+    AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $2) OR $2 = '')
+    ORDER BY %s %s, id ASC
+    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{organizationID, name, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.OrganizationID,
+			&collection.CreatedAt,
+			&collection.Name,
+			&collection.Description,
+			&collection.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// GetMovies() returns a page of the movies in a collection, in order, along
+// with the pagination metadata.
+func (m CollectionModel) GetMovies(collectionID int64, filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), movies.id, movies.organization_id,
+      movies.created_at, movies.title, movies.year, movies.runtime,
+      movies.genres, average_rating, rating_count, collection, movies.version
+    FROM collections_movies
+    INNER JOIN movies ON movies.id = collections_movies.movie_id`+movieRatings+movieCollection+`
+    WHERE collections_movies.collection_id = $1
+    ORDER BY collections_movies.%s %s
+    LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID, filters.limit(),
+		filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.OrganizationID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Collection,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Update() changes a collection's details, using the version number to
+// prevent edit conflicts. If movieIDs isn't nil, then they replace the
+// collection's movies in the same transaction, as described for setMovies(),
+// so that either both changes are made or neither is.
+func (m CollectionModel) Update(collection *Collection, movieIDs []int64) error {
+	query := `
+    UPDATE collections
+    SET name = $1, description = $2, version = version + 1
+    WHERE id = $3 AND version = $4 AND organization_id = $5
+    RETURNING version`
+
+	args := []any{
+		collection.Name,
+		collection.Description,
+		collection.ID,
+		collection.Version,
+		collection.OrganizationID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Scan into a local variable, so that the collection is left unchanged
+	// if the transaction is rolled back.
+	var version int32
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if movieIDs != nil {
+		err = setMovies(ctx, tx, collection, movieIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	collection.Version = version
+
+	return nil
+}
+
+// The setMovies() helper replaces the movies in a collection with the given
+// movies, in the given order, as part of a transaction. Every movie must be in
+// the same organization's catalog as the collection; if any of them isn't,
+// then an ErrRecordNotFound error is returned. If any of them already belongs
+// to a different collection, an ErrMovieInCollection error is returned.
+func setMovies(ctx context.Context, tx *sql.Tx, collection *Collection, movieIDs []int64) error {
+	query := `
+    DELETE FROM collections_movies
+    WHERE collection_id = $1`
+
+	_, err := tx.ExecContext(ctx, query, collection.ID)
+	if err != nil {
+		return err
+	}
+
+	// The WITH ORDINALITY clause numbers the movie IDs in the order that they
+	// were given, which gives us their positions in the collection.
+	query = `
+    INSERT INTO collections_movies (collection_id, movie_id, position)
+    SELECT $1, movies.id, given.position
+    FROM unnest($2::bigint[]) WITH ORDINALITY AS given (movie_id, position)
+    INNER JOIN movies ON movies.id = given.movie_id
+    WHERE movies.organization_id = $3`
+
+	result, err := tx.ExecContext(ctx, query, collection.ID, pq.Array(movieIDs),
+		collection.OrganizationID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collections_movies_movie_id_key"`:
+			return ErrMovieInCollection
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(movieIDs)) {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete() deletes a specific collection from an organization's catalog. The
+// movies in it aren't deleted; they just no longer belong to a collection.
+func (m CollectionModel) Delete(id, organizationID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM collections
+    WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
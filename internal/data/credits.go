@@ -0,0 +1,304 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Define constants for the roles that a person can be credited with on a
+// movie.
+const (
+	CreditRoleDirector = "director"
+	CreditRoleActor    = "actor"
+	CreditRoleWriter   = "writer"
+)
+
+// Define a custom ErrDuplicateCredit error, which is returned when a person
+// is credited on a movie in the same role more than once.
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// Define a Credit struct, which links a person to a movie that they worked on.
+// Actors can also have the name of the character that they played. Name is
+// the person's name, so that a movie's credits can be shown without looking
+// each person up.
+type Credit struct {
+	ID        int64  `json:"id"`
+	MovieID   int64  `json:"movie_id"`
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitzero"`
+}
+
+// Define a PersonCredit struct for one of the movies that a person has been
+// credited on, along with the role that they were credited with.
+type PersonCredit struct {
+	CreditID  int64  `json:"credit_id"`
+	Movie     *Movie `json:"movie"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitzero"`
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.MovieID > 0, "movie_id", "must be provided")
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.PermittedValue(credit.Role, CreditRoleDirector, CreditRoleActor, CreditRoleWriter),
+		"role", "must be one of director, actor or writer")
+
+	v.Check(credit.Character == "" || credit.Role == CreditRoleActor, "character",
+		"must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+}
+
+// Define the CreditModel type.
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new credit. The caller is responsible for checking that the
+// movie and the person belong to the same organization.
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+    INSERT INTO credits (movie_id, person_id, role, character)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get() retrieves a specific credit on a movie in an organization's catalog.
+func (m CreditModel) Get(id, organizationID int64) (*Credit, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT credits.id, credits.movie_id, credits.person_id, people.name,
+      credits.role, credits.character
+    FROM credits
+    INNER JOIN people ON people.id = credits.person_id
+    WHERE credits.id = $1 AND people.organization_id = $2`
+
+	var credit Credit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, organizationID).Scan(
+		&credit.ID,
+		&credit.MovieID,
+		&credit.PersonID,
+		&credit.Name,
+		&credit.Role,
+		&credit.Character,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &credit, nil
+}
+
+// GetAllForMovie() returns all of the credits on a movie, with the directors
+// first, then the writers, then the actors.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+    SELECT credits.id, credits.movie_id, credits.person_id, people.name,
+      credits.role, credits.character
+    FROM credits
+    INNER JOIN people ON people.id = credits.person_id
+    WHERE credits.movie_id = $1
+    ORDER BY array_position(ARRAY['director', 'writer', 'actor'], credits.role),
+      credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetAllForPerson() returns a page of the movies that a person has been
+// credited on, along with the pagination metadata. A person who has more than
+// one role on a movie appears once for each role.
+func (m CreditModel) GetAllForPerson(personID int64, filters Filters) ([]*PersonCredit, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), credits.id, credits.role, credits.character,
+      movies.id, movies.organization_id, movies.created_at, movies.title,
+      movies.year, movies.runtime, movies.genres, average_rating, rating_count,
+      collection, movies.version
+    FROM credits
+    INNER JOIN movies ON movies.id = credits.movie_id`+movieRatings+movieCollection+`
+    WHERE credits.person_id = $1
+    ORDER BY %s %s, movies.id ASC, credits.id ASC
+    LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID, filters.limit(),
+		filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	credits := []*PersonCredit{}
+
+	for rows.Next() {
+		var movie Movie
+		var credit PersonCredit
+
+		err := rows.Scan(
+			&totalRecords,
+			&credit.CreditID,
+			&credit.Role,
+			&credit.Character,
+			&movie.ID,
+			&movie.OrganizationID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Collection,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		credit.Movie = &movie
+
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return credits, metadata, nil
+}
+
+// Update() changes the role and character of a credit.
+func (m CreditModel) Update(credit *Credit) error {
+	query := `
+    UPDATE credits
+    SET role = $1, character = $2
+    WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, credit.Role, credit.Character, credit.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete() deletes a specific credit.
+func (m CreditModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM credits
+    WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
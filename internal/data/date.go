@@ -0,0 +1,42 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Define an error that our UnmarshalJSON() method can return if the JSON value
+// isn't a date in the expected format.
+var ErrInvalidDateFormat = errors.New("invalid date format")
+
+// Declare a custom Date type for values which are calendar dates rather than
+// moments in time, like the day that a user watched a movie. It is encoded in
+// JSON as a string in the format "2006-01-02".
+type Date struct {
+	time.Time
+}
+
+// Implement a MarshalJSON() method on the Date type so that it satisfies the
+// json.Marshaler interface, overriding the method of the embedded time.Time.
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Format(time.DateOnly))), nil
+}
+
+// Implement a UnmarshalJSON() method on the Date type so that it satisfies the
+// json.Unmarshaler interface. Like Runtime, this needs a pointer receiver.
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	t, err := time.Parse(time.DateOnly, unquotedJSONValue)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	d.Time = t
+
+	return nil
+}
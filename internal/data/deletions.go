@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define the AccountDeletionModel type. When a user asks for their account to
+// be deleted, it is scheduled here rather than being deleted straight away, to
+// give them a grace period in which they can change their mind.
+type AccountDeletionModel struct {
+	DB *sql.DB
+}
+
+// Schedule() schedules a user account to be purged after the given time. If a
+// deletion is already scheduled, the existing schedule is kept.
+func (m AccountDeletionModel) Schedule(userID int64, purgeAfter time.Time) error {
+	query := `
+    INSERT INTO account_deletions (user_id, purge_after)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, purgeAfter)
+	return err
+}
+
+// GetForUser() returns the time after which a user's account will be purged,
+// or nil if no deletion is scheduled.
+func (m AccountDeletionModel) GetForUser(userID int64) (*time.Time, error) {
+	query := `
+    SELECT purge_after
+    FROM account_deletions
+    WHERE user_id = $1`
+
+	var purgeAfter time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&purgeAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &purgeAfter, nil
+}
+
+// Cancel() cancels a scheduled deletion. If no deletion was scheduled, an
+// ErrRecordNotFound error is returned.
+func (m AccountDeletionModel) Cancel(userID int64) error {
+	query := `
+    DELETE FROM account_deletions
+    WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Purge() deletes every user account whose grace period has ended, returning
+// the number of accounts deleted. All of the user's other data (tokens,
+// permissions, API keys and so on) is removed by the ON DELETE CASCADE
+// constraints on the tables which reference users.
+//
+// Deleting an account is blocked while the user is an organization's only
+// admin, but the other admins might have left since then. So before deleting
+// anything, each organization which would be left without an admin has its
+// longest-standing remaining member made an admin in its place.
+func (m AccountDeletionModel) Purge() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	query := `
+    WITH purged AS (
+      SELECT user_id FROM account_deletions WHERE purge_after <= $1
+    ), orphaned AS (
+      SELECT DISTINCT organization_id
+      FROM organization_memberships
+      WHERE role = $2
+      AND user_id IN (SELECT user_id FROM purged)
+      AND NOT EXISTS (
+        SELECT 1 FROM organization_memberships others
+        WHERE others.organization_id = organization_memberships.organization_id
+        AND others.role = $2
+        AND others.user_id NOT IN (SELECT user_id FROM purged)
+      )
+    ), successors AS (
+      SELECT DISTINCT ON (organization_id) organization_id, user_id
+      FROM organization_memberships
+      WHERE organization_id IN (SELECT organization_id FROM orphaned)
+      AND user_id NOT IN (SELECT user_id FROM purged)
+      ORDER BY organization_id, created_at, user_id
+    )
+    UPDATE organization_memberships
+    SET role = $2
+    FROM successors
+    WHERE organization_memberships.organization_id = successors.organization_id
+    AND organization_memberships.user_id = successors.user_id`
+
+	_, err = tx.ExecContext(ctx, query, now, OrganizationRoleAdmin)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+    DELETE FROM users
+    WHERE id IN (
+      SELECT user_id FROM account_deletions WHERE purge_after <= $1
+    )`
+
+	result, err := tx.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected, tx.Commit()
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define the EmailChangeModel type. This stores the new email address that a
+// user has asked to change to, until they confirm it using the token sent to
+// that address. Each user can only have one pending email change at a time.
+type EmailChangeModel struct {
+	DB *sql.DB
+}
+
+// Set() records a pending email change for a user, replacing any previous
+// pending change.
+func (m EmailChangeModel) Set(userID int64, email string) error {
+	query := `
+    INSERT INTO email_changes (user_id, email)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE
+    SET email = EXCLUDED.email, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, email)
+	return err
+}
+
+// GetForUser() returns the pending new email address for a user, or an
+// ErrRecordNotFound error if there isn't one.
+func (m EmailChangeModel) GetForUser(userID int64) (string, error) {
+	query := `
+    SELECT email
+    FROM email_changes
+    WHERE user_id = $1`
+
+	var email string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
+
+// Delete() removes the pending email change for a user.
+func (m EmailChangeModel) Delete(userID int64) error {
+	query := `
+    DELETE FROM email_changes
+    WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
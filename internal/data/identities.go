@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define an Identity struct. An identity links a user account to an account at
+// an external OpenID Connect provider, which is identified by the provider's
+// issuer URL and the subject (user ID) that the provider uses.
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Define the IdentityModel type.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert() links an external identity to a user account.
+func (m IdentityModel) Insert(identity *Identity) error {
+	query := `
+    INSERT INTO user_identities (issuer, subject, user_id)
+    VALUES ($1, $2, $3)
+    RETURNING created_at`
+
+	args := []any{identity.Issuer, identity.Subject, identity.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.CreatedAt)
+}
+
+// GetUser() returns the user account linked to an external identity. If the
+// identity isn't linked to any account, an ErrRecordNotFound error is
+// returned.
+func (m IdentityModel) GetUser(issuer, subject string) (*User, error) {
+	query := `
+    SELECT users.id, users.created_at, users.name, users.email,
+      users.password_hash, users.activated, users.version
+    FROM users
+    INNER JOIN user_identities ON user_identities.user_id = users.id
+    WHERE user_identities.issuer = $1 AND user_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAllForUser() returns the external identities linked to a user account.
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+    SELECT issuer, subject, user_id, created_at
+    FROM user_identities
+    WHERE user_id = $1
+    ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+
+		err := rows.Scan(
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.UserID,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Define an Invitation struct. An invitation lets someone register an account
+// for a specific email address, even when open registration is turned off. The
+// new account is given the invitation's Role and any extra Permissions. Like a
+// token, the Plaintext is only known when the invitation is first created, and
+// is sent to the invitee by email rather than being included in any response.
+type Invitation struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Email       string      `json:"email"`
+	Plaintext   string      `json:"-"`
+	Hash        []byte      `json:"-"`
+	Role        string      `json:"role"`
+	Permissions Permissions `json:"permissions"`
+	InvitedBy   *int64      `json:"invited_by"`
+	Expiry      time.Time   `json:"expiry"`
+}
+
+// Generate a new random plaintext token for the Invitation, along with its
+// SHA-256 hash. These are the same length as our other tokens, so they can be
+// checked with ValidateTokenPlaintext().
+func (i *Invitation) generate() {
+	i.Plaintext = rand.Text()
+
+	hash := sha256.Sum256([]byte(i.Plaintext))
+	i.Hash = hash[:]
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(invitation.Role != "", "role", "must be provided")
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+}
+
+// Define the InvitationModel type.
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// Insert() generates a new plaintext token and adds the invitation, along with
+// its permissions, to the database. The role must already exist.
+func (m InvitationModel) Insert(invitation *Invitation) error {
+	invitation.generate()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+    INSERT INTO invitations (email, hash, role_id, invited_by, expiry)
+    SELECT $1, $2, roles.id, $4, $5 FROM roles WHERE roles.name = $3
+    RETURNING id, created_at`
+
+	args := []any{
+		invitation.Email,
+		invitation.Hash,
+		invitation.Role,
+		invitation.InvitedBy,
+		invitation.Expiry,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invitation.ID,
+		&invitation.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if len(invitation.Permissions) > 0 {
+		query = `
+      INSERT INTO invitations_permissions
+      SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, invitation.ID,
+			pq.Array(invitation.Permissions))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetForToken() retrieves an unexpired invitation by its plaintext token. If
+// there is no such invitation, an ErrRecordNotFound error is returned.
+func (m InvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    SELECT invitations.id, invitations.created_at, invitations.email,
+      roles.name, invitations.invited_by, invitations.expiry,
+      array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+    FROM invitations
+    INNER JOIN roles ON roles.id = invitations.role_id
+    LEFT JOIN invitations_permissions ON invitations_permissions.invitation_id = invitations.id
+    LEFT JOIN permissions ON invitations_permissions.permission_id = permissions.id
+    WHERE invitations.hash = $1 AND invitations.expiry > $2
+    GROUP BY invitations.id, roles.name`
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.Expiry,
+		pq.Array(&invitation.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// GetAll() returns every invitation, including expired ones, newest first.
+func (m InvitationModel) GetAll() ([]*Invitation, error) {
+	query := `
+    SELECT invitations.id, invitations.created_at, invitations.email,
+      roles.name, invitations.invited_by, invitations.expiry,
+      array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+    FROM invitations
+    INNER JOIN roles ON roles.id = invitations.role_id
+    LEFT JOIN invitations_permissions ON invitations_permissions.invitation_id = invitations.id
+    LEFT JOIN permissions ON invitations_permissions.permission_id = permissions.id
+    GROUP BY invitations.id, roles.name
+    ORDER BY invitations.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.CreatedAt,
+			&invitation.Email,
+			&invitation.Role,
+			&invitation.InvitedBy,
+			&invitation.Expiry,
+			pq.Array(&invitation.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Delete() withdraws a specific invitation. If no matching invitation exists,
+// an ErrRecordNotFound error is returned.
+func (m InvitationModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM invitations
+    WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForEmail() deletes every invitation for an email address. This is
+// used once someone has registered, since any other invitations that they
+// were sent are no longer needed.
+func (m InvitationModel) DeleteAllForEmail(email string) error {
+	query := `
+    DELETE FROM invitations
+    WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
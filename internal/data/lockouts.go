@@ -0,0 +1,185 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Define a LockoutPolicy struct to hold the settings which control when an
+// account is locked. After every Threshold consecutive failures the account is
+// locked, starting at Duration and doubling for each subsequent lockout, up to
+// a maximum of MaxDuration.
+type LockoutPolicy struct {
+	Threshold   int
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// The lockoutDuration() method returns how long an account should be locked
+// for after the given number of consecutive failures, or zero if it shouldn't
+// be locked at all.
+func (p LockoutPolicy) lockoutDuration(failures int) time.Duration {
+	if p.Threshold < 1 || failures < p.Threshold || failures%p.Threshold != 0 {
+		return 0
+	}
+
+	d := p.Duration
+	for i := 1; i < failures/p.Threshold; i++ {
+		d *= 2
+		if d >= p.MaxDuration {
+			return p.MaxDuration
+		}
+	}
+
+	return min(d, p.MaxDuration)
+}
+
+// Define the LoginFailureModel type, which tracks consecutive failed login
+// attempts for each user account.
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// GetLockedUntil() returns the time that a user's account is locked until, or
+// nil if it isn't currently locked.
+func (m LoginFailureModel) GetLockedUntil(userID int64) (*time.Time, error) {
+	return m.getLockedUntil("login_failures", "user_id", userID)
+}
+
+// GetLockedUntilForEmail() is like GetLockedUntil(), but for an email address
+// which doesn't belong to any account.
+func (m LoginFailureModel) GetLockedUntilForEmail(email string) (*time.Time, error) {
+	return m.getLockedUntil("unknown_login_failures", "email", email)
+}
+
+// RecordFailure() increments the number of consecutive failed login attempts
+// for a user. Failures more than 24 hours apart aren't considered consecutive,
+// so the count starts again from one. If this failure means that the account
+// should now be locked, then the lock is applied and the time that it expires
+// is returned. Otherwise the returned time is nil.
+func (m LoginFailureModel) RecordFailure(
+	userID int64,
+	policy LockoutPolicy,
+) (*time.Time, error) {
+	return m.recordFailure("login_failures", "user_id", userID, policy)
+}
+
+// RecordFailureForEmail() is like RecordFailure(), but for an email address
+// which doesn't belong to any account. Tracking these in the same way means
+// that they get locked too, so the lockout doesn't reveal which addresses
+// have accounts.
+func (m LoginFailureModel) RecordFailureForEmail(
+	email string,
+	policy LockoutPolicy,
+) (*time.Time, error) {
+	return m.recordFailure("unknown_login_failures", "email", email, policy)
+}
+
+// The getLockedUntil() and recordFailure() methods do the work for both
+// tables. The table and column names are always constants, never user input.
+func (m LoginFailureModel) getLockedUntil(table, column string, key any) (*time.Time, error) {
+	query := fmt.Sprintf(`
+    SELECT locked_until
+    FROM %s
+    WHERE %s = $1 AND locked_until > $2`, table, column)
+
+	var lockedUntil time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &lockedUntil, nil
+}
+
+func (m LoginFailureModel) recordFailure(
+	table, column string,
+	key any,
+	policy LockoutPolicy,
+) (*time.Time, error) {
+	query := fmt.Sprintf(`
+    INSERT INTO %[1]s (%[2]s, failures, last_failed_at)
+    VALUES ($1, 1, NOW())
+    ON CONFLICT (%[2]s) DO UPDATE
+    SET failures = CASE
+        WHEN %[1]s.last_failed_at < NOW() - INTERVAL '24 hours' THEN 1
+        ELSE %[1]s.failures + 1
+      END,
+      last_failed_at = NOW()
+    RETURNING failures`, table, column)
+
+	var failures int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&failures)
+	if err != nil {
+		return nil, err
+	}
+
+	d := policy.lockoutDuration(failures)
+	if d == 0 {
+		return nil, nil
+	}
+
+	lockedUntil := time.Now().Add(d)
+
+	query = fmt.Sprintf(`
+    UPDATE %s
+    SET locked_until = $1
+    WHERE %s = $2`, table, column)
+
+	_, err = m.DB.ExecContext(ctx, query, lockedUntil, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lockedUntil, nil
+}
+
+// Reset() clears the failed login attempts for a user after they log in
+// successfully.
+func (m LoginFailureModel) Reset(userID int64) error {
+	query := `
+    DELETE FROM login_failures
+    WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteStaleForEmails() deletes the failed login records for unknown email
+// addresses which are no longer locked and whose failures are too old to count
+// any more, returning the number of records deleted.
+func (m LoginFailureModel) DeleteStaleForEmails() (int64, error) {
+	query := `
+    DELETE FROM unknown_login_failures
+    WHERE last_failed_at < NOW() - INTERVAL '24 hours'
+    AND (locked_until IS NULL OR locked_until < NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -15,19 +15,51 @@ var (
 // Create a Models struct which wraps the MovieModel. We'll add other models to
 // this, like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	AccountDeletions AccountDeletionModel
+	APIKeys          APIKeyModel
+	Collections      CollectionModel
+	Credits          CreditModel
+	EmailChanges     EmailChangeModel
+	Identities       IdentityModel
+	Invitations      InvitationModel
+	LoginFailures    LoginFailureModel
+	Movies           MovieModel
+	Organizations    OrganizationModel
+	People           PersonModel
+	Permissions      PermissionModel
+	Reviews          ReviewModel
+	Revocations      RevocationModel
+	Roles            RoleModel
+	Tokens           TokenModel
+	TOTP             TOTPModel
+	Users            UserModel
+	Watched          WatchedModel
+	Watchlist        WatchlistModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct
 // containing the initialized MovieModel.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		AccountDeletions: AccountDeletionModel{DB: db},
+		APIKeys:          APIKeyModel{DB: db},
+		Collections:      CollectionModel{DB: db},
+		Credits:          CreditModel{DB: db},
+		EmailChanges:     EmailChangeModel{DB: db},
+		Identities:       IdentityModel{DB: db},
+		Invitations:      InvitationModel{DB: db},
+		LoginFailures:    LoginFailureModel{DB: db},
+		Movies:           MovieModel{DB: db},
+		Organizations:    OrganizationModel{DB: db},
+		People:           PersonModel{DB: db},
+		Permissions:      PermissionModel{DB: db},
+		Reviews:          ReviewModel{DB: db},
+		Revocations:      RevocationModel{DB: db},
+		Roles:            RoleModel{DB: db},
+		Tokens:           TokenModel{DB: db},
+		TOTP:             TOTPModel{DB: db},
+		Users:            UserModel{DB: db},
+		Watched:          WatchedModel{DB: db},
+		Watchlist:        WatchlistModel{DB: db},
 	}
 }
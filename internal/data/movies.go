@@ -15,16 +15,33 @@ import (
 // Annotate the Movie struct with struct tags to control how the keys appear in
 // the JSON-encoded output.
 type Movie struct {
-	ID        int64     `json:"id"`               // Unique integer ID for the movie
-	CreatedAt time.Time `json:"-"`                // Timestamp for when the movie is added to our database
-	Title     string    `json:"title"`            // Movie title
-	Year      int32     `json:"year,omitzero"`    // Movie release year
-	Runtime   Runtime   `json:"runtime,omitzero"` // Movie runtime (in minutes)
-	Genres    []string  `json:"genres,omitzero"`  // Slice of genres for the movie (romance, comedy, etc)
-	Version   int32     `json:"version"`          // The version number starts at 1 and will be incremented
+	ID             int64            `json:"id"`                    // Unique integer ID for the movie
+	OrganizationID int64            `json:"-"`                     // ID of the organization whose catalog the movie belongs to
+	CreatedAt      time.Time        `json:"-"`                     // Timestamp for when the movie is added to our database
+	Title          string           `json:"title"`                 // Movie title
+	Year           int32            `json:"year,omitzero"`         // Movie release year
+	Runtime        Runtime          `json:"runtime,omitzero"`      // Movie runtime (in minutes)
+	Genres         []string         `json:"genres,omitzero"`       // Slice of genres for the movie (romance, comedy, etc)
+	AverageRating  *float64         `json:"average_rating"`        // Average review score, or nil if there are no reviews
+	RatingCount    int64            `json:"rating_count"`          // Number of reviews
+	Collection     *MovieCollection `json:"collection,omitzero"`   // The collection that the movie belongs to, if any
+	OnWatchlist    *bool            `json:"on_watchlist,omitzero"` // Whether the movie is on the requesting user's watchlist, if known
+	Version        int32            `json:"version"`               // The version number starts at 1 and will be incremented
 	//  each time the movie information is updated.
 }
 
+// The movieRatings constant is a lateral join which calculates the
+// average_rating and rating_count columns for each movie from its reviews.
+// Both Get() and GetAll() include it, so that every movie we return carries its
+// ratings.
+const movieRatings = `
+    LEFT JOIN LATERAL (
+      SELECT round(avg(reviews.score), 1)::float8 AS average_rating,
+        count(*) AS rating_count
+      FROM reviews
+      WHERE reviews.movie_id = movies.id
+    ) ratings ON true`
+
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
 type MovieModel struct {
 	DB *sql.DB
@@ -51,15 +68,16 @@ func (m MovieModel) Insert(movie *Movie) error {
 	// Define the SQL query for inserting a new record in the movies table and
 	// returning the system-generated data.
 	query := `
-    INSERT INTO movies (title, year, runtime, genres)
-    VALUES ($1, $2, $3, $4)
+    INSERT INTO movies (title, year, runtime, genres, organization_id)
+    VALUES ($1, $2, $3, $4, $5)
     RETURNING id, created_at, version`
 
 	// Create an args slice containing the values for the placeholder parameters
 	// from the movie struct. Declaring this slice immediately next to our SQL
 	// query helps to make it nice and clear *what values are being used where*
 	// in the query.
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres),
+		movie.OrganizationID}
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -71,7 +89,54 @@ func (m MovieModel) Insert(movie *Movie) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// InsertBatch() adds many movies at once, using a single COPY statement in a
+// transaction so that either all of the movies are added or none of them are.
+// Unlike Insert(), it doesn't fill in the system-generated fields of the
+// movies.
+func (m MovieModel) InsertBatch(movies []*Movie) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies",
+		"organization_id", "title", "year", "runtime", "genres"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, movie := range movies {
+		_, err = stmt.ExecContext(ctx, movie.OrganizationID, movie.Title,
+			movie.Year, movie.Runtime, pq.Array(movie.Genres))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Calling Exec() with no arguments flushes the buffered rows and ends the
+	// COPY.
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = stmt.Close()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get() retrieves a specific movie from an organization's catalog. Including
+// the organization ID in the query means that a movie belonging to another
+// organization is treated just like one which doesn't exist.
+func (m MovieModel) Get(id, organizationID int64) (*Movie, error) {
 	// The PostgreSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID
 	// values less than that. To avoid making an unnecessary database call, we
@@ -82,9 +147,10 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// Define the SQL query for retrieving the movie data.
 	query := `
-    SELECT id, created_at, title, year, runtime, genres, version
-    FROM movies
-    WHERE id = $1`
+    SELECT id, organization_id, created_at, title, year, runtime, genres,
+      average_rating, rating_count, collection, version
+    FROM movies` + movieRatings + movieCollection + `
+    WHERE id = $1 AND organization_id = $2`
 
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
@@ -105,13 +171,17 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// function again.
 	// Importantly, update the Scan() parameters so the the pg_sleep(8) return
 	// value is scanned into a []byte slice.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, organizationID).Scan(
 		&movie.ID,
+		&movie.OrganizationID,
 		&movie.CreatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&movie.AverageRating,
+		&movie.RatingCount,
+		&movie.Collection,
 		&movie.Version,
 	)
 
@@ -138,7 +208,7 @@ func (m MovieModel) Update(movie *Movie) error {
 	query := `
     UPDATE movies
     SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-    WHERE id = $5 AND version = $6
+    WHERE id = $5 AND version = $6 AND organization_id = $7
     RETURNING version`
 
 	// Create an args slice containing the values for the placeholder parameters.
@@ -149,6 +219,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
+		movie.OrganizationID,
 	}
 
 	// Create a context with a 3-second timeout.
@@ -174,7 +245,8 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete() deletes a specific movie from an organization's catalog.
+func (m MovieModel) Delete(id, organizationID int64) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
@@ -183,7 +255,7 @@ func (m MovieModel) Delete(id int64) error {
 	// Construct the SQL query to delete the record.
 	query := `
     DELETE FROM movies
-    WHERE id = $1`
+    WHERE id = $1 AND organization_id = $2`
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -192,7 +264,7 @@ func (m MovieModel) Delete(id int64) error {
 	// Execute the SQL query using the Exec() method, passing in the id variable
 	// as the value for the placeholder parameter. The Exec() method returns a
 	// sql.Result object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, organizationID)
 	if err != nil {
 		return err
 	}
@@ -214,25 +286,56 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-// Create a new GetAll() method which returns a slice of movies. Although we're
-// not using them right now, we've set this up to accept the various filter
-// parameters as arguments.
+// Create a new GetAll() method which returns a slice of movies from an
+// organization's catalog. Although we're not using them right now, we've set
+// this up to accept the various filter parameters as arguments. Each movie is
+// also flagged with whether it is on the watchlist of the user with the given
+// ID.
+//
+// The director and cast filters match movies which credit a person with a
+// matching name in that role. The subqueries find the matching people using
+// the GIN index on their names, and then their credits using the index on
+// person_id and role.
 func (m MovieModel) GetAll(
+	organizationID int64,
+	userID int64,
 	title string,
 	genres []string,
+	director string,
+	cast string,
 	filters Filters,
 ) ([]*Movie, Metadata, error) {
 	// Construct the SQL query to retrieve all movie records.
 	// Add an ORDER BY clause and interpolate the sort column and direction.
 	// Importantly notice that we also include a secondary sort on the movie ID
-	// to ensure a consistent ordering.
+	// to ensure a consistent ordering. Movies without any reviews have a NULL
+	// average_rating, so NULLS LAST keeps them at the end of the list whichever
+	// direction we're sorting in.
 	query := fmt.Sprintf(`
-    SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-    FROM movies
-    WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-    AND (genres @> $2 OR $2 = '{}')
-    ORDER BY %s %s, id ASC
-    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+    SELECT count(*) OVER(), id, organization_id, created_at, title, year,
+      runtime, genres, average_rating, rating_count, collection, version,
+      EXISTS (
+        SELECT 1 FROM watchlist
+        WHERE watchlist.movie_id = movies.id AND watchlist.user_id = $6
+      )
+    FROM movies`+movieRatings+movieCollection+`
+    WHERE organization_id = $1
+    AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
+    AND (genres @> $3 OR $3 = '{}')
+    AND (id IN (
+      SELECT credits.movie_id FROM credits
+      INNER JOIN people ON people.id = credits.person_id
+      WHERE credits.role = 'director'
+      AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $7)
+    ) OR $7 = '')
+    AND (id IN (
+      SELECT credits.movie_id FROM credits
+      INNER JOIN people ON people.id = credits.person_id
+      WHERE credits.role = 'actor'
+      AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $8)
+    ) OR $8 = '')
+    ORDER BY %s %s NULLS LAST, id ASC
+    LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -242,7 +345,8 @@ func (m MovieModel) GetAll(
 	// the values for the placeholders in a slice. Notice here how we call the
 	// limit() and offset() methods on the Filters struct to get the appropriate
 	// values for the LIMIT and OFFSET clauses.
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []any{organizationID, title, pq.Array(genres), filters.limit(),
+		filters.offset(), userID, director, cast}
 
 	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
 	// containing the result.
@@ -265,23 +369,31 @@ func (m MovieModel) GetAll(
 		// Initialize an empty movie struct to hold the data for an individual
 		// movie.
 		var movie Movie
+		var onWatchlist bool
 
 		// Scab the values from the row into the Movie struct. Again, note that
 		// we're using the pq.Array() adapter on the genres field here.
 		err := rows.Scan(
 			&totalRecords,
 			&movie.ID,
+			&movie.OrganizationID,
 			&movie.CreatedAt,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Collection,
 			&movie.Version,
+			&onWatchlist,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		movie.OnWatchlist = &onWatchlist
+
 		// Add the Movie struct to the slice.
 		movies = append(movies, &movie)
 	}
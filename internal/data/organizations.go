@@ -0,0 +1,514 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// Define constants for the roles that a user can have within an organization.
+// Viewers can read the organization's catalog, editors can change it too, and
+// admins can also manage the organization and its members.
+const (
+	OrganizationRoleViewer = "viewer"
+	OrganizationRoleEditor = "editor"
+	OrganizationRoleAdmin  = "admin"
+)
+
+// OrganizationRoles lists the organization roles in increasing order of
+// privilege. Each role can do everything that the roles before it can.
+var OrganizationRoles = []string{
+	OrganizationRoleViewer,
+	OrganizationRoleEditor,
+	OrganizationRoleAdmin,
+}
+
+// ErrLastOrganizationAdmin is returned when a change would leave an
+// organization without any admins.
+var ErrLastOrganizationAdmin = errors.New("last organization admin")
+
+// Define an Organization struct. Each organization has its own separate movie
+// catalog. When an organization is fetched for a specific user, Role holds
+// that user's role within it.
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitzero"`
+	Version   int32     `json:"version"`
+}
+
+// Define a Membership struct, which records a user's role within an
+// organization. The Name and Email fields are the member's, so that
+// organization admins can see who their members are.
+type Membership struct {
+	OrganizationID int64     `json:"-"`
+	UserID         int64     `json:"user_id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// HasRole() reports whether the user's role in the organization is at least as
+// privileged as the given role.
+func (o *Organization) HasRole(role string) bool {
+	return hasOrganizationRole(o.Role, role)
+}
+
+// HasRole() reports whether the membership's role is at least as privileged as
+// the given role.
+func (m *Membership) HasRole(role string) bool {
+	return hasOrganizationRole(m.Role, role)
+}
+
+func hasOrganizationRole(held, required string) bool {
+	return slices.Index(OrganizationRoles, held) >= slices.Index(OrganizationRoles, required)
+}
+
+func ValidateOrganization(v *validator.Validator, organization *Organization) {
+	v.Check(organization.Name != "", "name", "must be provided")
+	v.Check(len(organization.Name) <= 500, "name", "must not be more than 500 bytes long")
+}
+
+func ValidateOrganizationRole(v *validator.Validator, role string) {
+	v.Check(role != "", "role", "must be provided")
+	v.Check(validator.PermittedValue(role, OrganizationRoles...), "role",
+		"must be one of viewer, editor or admin")
+}
+
+// Define the OrganizationModel type.
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new organization, and makes the user who created it its
+// first admin. Both inserts happen in a single transaction so that we never
+// end up with an organization that nobody can manage.
+func (m OrganizationModel) Insert(organization *Organization, ownerID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+    INSERT INTO organizations (name)
+    VALUES ($1)
+    RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, organization.Name).Scan(
+		&organization.ID,
+		&organization.CreatedAt,
+		&organization.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	query = `
+    INSERT INTO organization_memberships (organization_id, user_id, role)
+    VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, query, organization.ID, ownerID,
+		OrganizationRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	organization.Role = OrganizationRoleAdmin
+
+	return tx.Commit()
+}
+
+// GetForUser() retrieves an organization that a specific user is a member of,
+// along with their role in it. If the organization doesn't exist or the user
+// isn't a member, an ErrRecordNotFound error is returned.
+func (m OrganizationModel) GetForUser(id, userID int64) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT organizations.id, organizations.created_at, organizations.name,
+      organization_memberships.role, organizations.version
+    FROM organizations
+    INNER JOIN organization_memberships
+      ON organization_memberships.organization_id = organizations.id
+    WHERE organizations.id = $1 AND organization_memberships.user_id = $2`
+
+	var organization Organization
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&organization.ID,
+		&organization.CreatedAt,
+		&organization.Name,
+		&organization.Role,
+		&organization.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &organization, nil
+}
+
+// GetAllForUser() returns every organization that a user is a member of, along
+// with their role in each one.
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Organization, error) {
+	query := `
+    SELECT organizations.id, organizations.created_at, organizations.name,
+      organization_memberships.role, organizations.version
+    FROM organizations
+    INNER JOIN organization_memberships
+      ON organization_memberships.organization_id = organizations.id
+    WHERE organization_memberships.user_id = $1
+    ORDER BY organizations.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	organizations := []*Organization{}
+
+	for rows.Next() {
+		var organization Organization
+
+		err := rows.Scan(
+			&organization.ID,
+			&organization.CreatedAt,
+			&organization.Name,
+			&organization.Role,
+			&organization.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		organizations = append(organizations, &organization)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return organizations, nil
+}
+
+// Update() renames an organization, using the version number to prevent edit
+// conflicts.
+func (m OrganizationModel) Update(organization *Organization) error {
+	query := `
+    UPDATE organizations
+    SET name = $1, version = version + 1
+    WHERE id = $2 AND version = $3
+    RETURNING version`
+
+	args := []any{organization.Name, organization.ID, organization.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&organization.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() deletes an organization. Its memberships and its whole movie
+// catalog are removed by the ON DELETE CASCADE constraints.
+func (m OrganizationModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM organizations
+    WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetMembership() retrieves a user's membership of an organization. If the
+// user isn't a member, an ErrRecordNotFound error is returned.
+func (m OrganizationModel) GetMembership(organizationID, userID int64) (*Membership, error) {
+	if organizationID < 1 || userID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT organization_memberships.organization_id, users.id, users.name,
+      users.email, organization_memberships.role,
+      organization_memberships.created_at
+    FROM organization_memberships
+    INNER JOIN users ON users.id = organization_memberships.user_id
+    WHERE organization_memberships.organization_id = $1
+    AND organization_memberships.user_id = $2`
+
+	var membership Membership
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, organizationID, userID).Scan(
+		&membership.OrganizationID,
+		&membership.UserID,
+		&membership.Name,
+		&membership.Email,
+		&membership.Role,
+		&membership.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &membership, nil
+}
+
+// GetAllMembers() returns every member of an organization.
+func (m OrganizationModel) GetAllMembers(organizationID int64) ([]*Membership, error) {
+	query := `
+    SELECT organization_memberships.organization_id, users.id, users.name,
+      users.email, organization_memberships.role,
+      organization_memberships.created_at
+    FROM organization_memberships
+    INNER JOIN users ON users.id = organization_memberships.user_id
+    WHERE organization_memberships.organization_id = $1
+    ORDER BY users.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*Membership{}
+
+	for rows.Next() {
+		var membership Membership
+
+		err := rows.Scan(
+			&membership.OrganizationID,
+			&membership.UserID,
+			&membership.Name,
+			&membership.Email,
+			&membership.Role,
+			&membership.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &membership)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// AddMember() adds a user to an organization with the given role. If the user
+// is already a member, their existing role is left as it is.
+func (m OrganizationModel) AddMember(organizationID, userID int64, role string) error {
+	query := `
+    INSERT INTO organization_memberships (organization_id, user_id, role)
+    VALUES ($1, $2, $3)
+    ON CONFLICT (organization_id, user_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, organizationID, userID, role)
+	return err
+}
+
+// UpdateMemberRole() changes a member's role within an organization. If the
+// user isn't a member, an ErrRecordNotFound error is returned, and if the
+// change would demote the organization's last admin, an
+// ErrLastOrganizationAdmin error is returned.
+func (m OrganizationModel) UpdateMemberRole(organizationID, userID int64, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != OrganizationRoleAdmin {
+		err = checkOtherAdmins(ctx, tx, organizationID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+    UPDATE organization_memberships
+    SET role = $3
+    WHERE organization_id = $1 AND user_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember() removes a user from an organization. If the user isn't a
+// member, an ErrRecordNotFound error is returned, and if they are the
+// organization's last admin, an ErrLastOrganizationAdmin error is returned.
+func (m OrganizationModel) RemoveMember(organizationID, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkOtherAdmins(ctx, tx, organizationID, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+    DELETE FROM organization_memberships
+    WHERE organization_id = $1 AND user_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, organizationID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// The checkOtherAdmins() helper returns an ErrLastOrganizationAdmin error if
+// the given user is the only admin of an organization. It locks the
+// organization's row until the transaction ends, so that two admins can't
+// each demote or remove the other at the same time and leave the
+// organization with none.
+func checkOtherAdmins(ctx context.Context, tx *sql.Tx, organizationID, userID int64) error {
+	_, err := tx.ExecContext(ctx,
+		`SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, organizationID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+    SELECT
+      count(*) FILTER (WHERE user_id = $2),
+      count(*) FILTER (WHERE user_id <> $2)
+    FROM organization_memberships
+    WHERE organization_id = $1 AND role = $3`
+
+	var isAdmin, otherAdmins int
+
+	err = tx.QueryRowContext(ctx, query, organizationID, userID,
+		OrganizationRoleAdmin).Scan(&isAdmin, &otherAdmins)
+	if err != nil {
+		return err
+	}
+
+	if isAdmin > 0 && otherAdmins == 0 {
+		return ErrLastOrganizationAdmin
+	}
+
+	return nil
+}
+
+// CountSoleAdminOrganizations() returns the number of organizations that the
+// given user is the only admin of.
+func (m OrganizationModel) CountSoleAdminOrganizations(userID int64) (int, error) {
+	query := `
+    SELECT count(*)
+    FROM organization_memberships
+    WHERE user_id = $1 AND role = $2
+    AND NOT EXISTS (
+      SELECT 1 FROM organization_memberships others
+      WHERE others.organization_id = organization_memberships.organization_id
+      AND others.user_id <> $1 AND others.role = $2
+    )`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, userID,
+		OrganizationRoleAdmin).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// The common_passwords.txt.gz file contains a gzipped, sorted, lowercase list
+// of commonly used and breached passwords, one per line. It was generated from
+// the password frequency list bundled with zxcvbn-go (MIT licensed), keeping
+// only passwords at least 8 bytes long, since anything shorter is already
+// rejected by ValidatePasswordPlaintext(). To use a different list, replace the
+// file with one in the same format.
+//
+//go:embed "common_passwords.txt.gz"
+var commonPasswordsGz []byte
+
+// The common passwords list is decompressed the first time it's needed, and
+// then kept in memory for the lifetime of the application.
+var (
+	commonPasswords     []string
+	commonPasswordsOnce sync.Once
+)
+
+func loadCommonPasswords() {
+	zr, err := gzip.NewReader(bytes.NewReader(commonPasswordsGz))
+	if err != nil {
+		// The list is embedded in the binary at build time, so if it can't be
+		// read this is a programming error rather than a runtime one.
+		panic("unable to read common passwords list: " + err.Error())
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		commonPasswords = append(commonPasswords, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		panic("unable to read common passwords list: " + err.Error())
+	}
+
+	// The file should already be sorted, but sort it anyway so that binary
+	// searching it is always correct.
+	slices.Sort(commonPasswords)
+}
+
+// IsCommonPassword reports whether a password appears in the list of common
+// passwords. The check is case-insensitive and uses a binary search, so it
+// stays fast however long the list is.
+func IsCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(loadCommonPasswords)
+
+	_, found := slices.BinarySearch(commonPasswords, strings.ToLower(password))
+	return found
+}
+
+// ValidatePasswordStrength checks that a new password isn't a common or
+// breached password, and doesn't contain the user's email address or name.
+// This is separate from ValidatePasswordPlaintext() because it should only be
+// applied when a password is being set, not when an existing user logs in.
+func ValidatePasswordStrength(v *validator.Validator, password, email, name string) {
+	v.Check(!IsCommonPassword(password), "password",
+		"is too common, please choose a different password")
+
+	lower := strings.ToLower(password)
+
+	// Check the whole email address and the part before the @ sign. Very short
+	// values are ignored, since they're likely to match by coincidence.
+	localPart, _, _ := strings.Cut(email, "@")
+	for _, s := range []string{email, localPart} {
+		if len(s) >= 3 && strings.Contains(lower, strings.ToLower(s)) {
+			v.AddError("password", "must not contain your email address")
+			break
+		}
+	}
+
+	// Likewise check each part of the user's name.
+	for _, s := range strings.Fields(name) {
+		if len(s) >= 3 && strings.Contains(lower, strings.ToLower(s)) {
+			v.AddError("password", "must not contain your name")
+			break
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// Define a Person struct for the directors, actors and writers who are
+// credited on movies. Like movies, people belong to an organization's catalog.
+type Person struct {
+	ID             int64     `json:"id"`
+	OrganizationID int64     `json:"-"`
+	CreatedAt      time.Time `json:"-"`
+	Name           string    `json:"name"`
+	Version        int32     `json:"version"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+}
+
+// Define the PersonModel type.
+type PersonModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new person to an organization's catalog.
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+    INSERT INTO people (organization_id, name)
+    VALUES ($1, $2)
+    RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, person.OrganizationID, person.Name).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Version,
+	)
+}
+
+// Get() retrieves a specific person from an organization's catalog.
+func (m PersonModel) Get(id, organizationID int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, organization_id, created_at, name, version
+    FROM people
+    WHERE id = $1 AND organization_id = $2`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, organizationID).Scan(
+		&person.ID,
+		&person.OrganizationID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// GetAll() returns a page of the people in an organization's catalog,
+// optionally filtered by name in the same way that movies are filtered by
+// title.
+func (m PersonModel) GetAll(organizationID int64, name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), id, organization_id, created_at, name, version
+    FROM people
+    WHERE organization_id = $1
+    AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $2) OR $2 = '')
+    ORDER BY %s %s, id ASC
+    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{organizationID, name, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.OrganizationID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// Update() changes a person's details, using the version number to prevent
+// edit conflicts.
+func (m PersonModel) Update(person *Person) error {
+	query := `
+    UPDATE people
+    SET name = $1, version = version + 1
+    WHERE id = $2 AND version = $3 AND organization_id = $4
+    RETURNING version`
+
+	args := []any{person.Name, person.ID, person.Version, person.OrganizationID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() deletes a specific person from an organization's catalog, along
+// with all of their credits.
+func (m PersonModel) Delete(id, organizationID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM people
+    WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, organizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -2,7 +2,9 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"slices"
 	"time"
 
@@ -25,16 +27,22 @@ type PermissionModel struct {
 }
 
 // The GetAllForUser() method returns all permission codes for a specific user
-// in a Permission slice. The code in this method should feel very familiar ---
-// it uses the standard pattern that we've already seen before for retrieving
-// multiple data rows in a SQL query.
+// in a Permission slice. These are the user's effective permissions: the ones
+// granted by their roles, plus any which have been granted to them directly.
+// The UNION removes any duplicates.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
     SELECT permissions.code
     FROM permissions
     INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-    INNER JOIN users ON users_permissions.user_id = users.id
-    WHERE users.id = $1`
+    WHERE users_permissions.user_id = $1
+    UNION
+    SELECT permissions.code
+    FROM permissions
+    INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+    INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+    WHERE users_roles.user_id = $1
+    ORDER BY code`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -66,11 +74,13 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 // Add the provided permission codes for a specific user. Notice that we're
 // using a variadic parameter for the codes so that we can assign multiple
-// permissions in a single call.
+// permissions in a single call. Permissions that the user already has are
+// ignored.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
     INSERT INTO users_permissions
-    SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+    SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+    ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -78,3 +88,101 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// Remove the provided permission codes from a specific user. Note that this
+// only removes permissions which were granted to the user directly; any that
+// come from the user's roles are unaffected.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+    DELETE FROM users_permissions
+    USING permissions
+    WHERE users_permissions.permission_id = permissions.id
+    AND users_permissions.user_id = $1
+    AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// GetAllForToken() returns the permission codes that a token is restricted
+// to, and whether it is scoped at all. A scoped token whose permissions have
+// all since been deleted stays scoped, with an empty slice of permissions.
+func (m PermissionModel) GetAllForToken(tokenPlaintext string) (Permissions, bool, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    SELECT tokens.scoped,
+      COALESCE(array_agg(permissions.code ORDER BY permissions.code)
+        FILTER (WHERE permissions.code IS NOT NULL), '{}')
+    FROM tokens
+    LEFT JOIN tokens_permissions ON tokens_permissions.token_id = tokens.id
+    LEFT JOIN permissions ON tokens_permissions.permission_id = permissions.id
+    WHERE tokens.hash = $1
+    GROUP BY tokens.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var scoped bool
+	var permissions Permissions
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:]).Scan(&scoped, pq.Array(&permissions))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, nil
+		default:
+			return nil, false, err
+		}
+	}
+
+	if !scoped {
+		return nil, false, nil
+	}
+
+	// Make sure that the permissions of a scoped token are never nil, since
+	// elsewhere nil means unrestricted.
+	if permissions == nil {
+		permissions = Permissions{}
+	}
+
+	return permissions, true, nil
+}
+
+// GetAll() returns every permission code which exists.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+    SELECT code
+    FROM permissions
+    ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
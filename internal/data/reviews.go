@@ -0,0 +1,276 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+)
+
+// Define a custom ErrDuplicateReview error, which is returned when a user
+// tries to review a movie that they have already reviewed.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Define a Review struct. Each user can review a movie once, giving it a score
+// from 1 to 10 and, optionally, some text. Author is the name of the user who
+// wrote the review.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Author    string    `json:"author"`
+	Score     int32     `json:"score"`
+	Text      string    `json:"text,omitzero"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Score >= 1, "score", "must be at least 1")
+	v.Check(review.Score <= 10, "score", "must not be more than 10")
+
+	v.Check(len(review.Text) <= 10_000, "text", "must not be more than 10000 bytes long")
+}
+
+// Define the ReviewModel type.
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new review. If the user has already reviewed the movie, an
+// ErrDuplicateReview error is returned.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+    WITH review AS (
+      INSERT INTO reviews (movie_id, user_id, score, text)
+      VALUES ($1, $2, $3, $4)
+      RETURNING id, created_at, user_id, version
+    )
+    SELECT review.id, review.created_at, users.name, review.version
+    FROM review
+    INNER JOIN users ON users.id = review.user_id`
+
+	args := []any{review.MovieID, review.UserID, review.Score, review.Text}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.Author,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get() retrieves a specific review of a specific movie.
+func (m ReviewModel) Get(id, movieID int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT reviews.id, reviews.created_at, reviews.movie_id, reviews.user_id,
+      users.name, reviews.score, reviews.text, reviews.version
+    FROM reviews
+    INNER JOIN users ON users.id = reviews.user_id
+    WHERE reviews.id = $1 AND reviews.movie_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Author,
+		&review.Score,
+		&review.Text,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie() returns a page of the reviews of a movie, along with the
+// pagination metadata.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), reviews.id, reviews.created_at, reviews.movie_id,
+      reviews.user_id, users.name, reviews.score, reviews.text, reviews.version
+    FROM reviews
+    INNER JOIN users ON users.id = reviews.user_id
+    WHERE reviews.movie_id = $1
+    ORDER BY reviews.%s %s, reviews.id ASC
+    LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(),
+		filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Author,
+			&review.Score,
+			&review.Text,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// GetAllForUser() returns every review written by a specific user, newest
+// first.
+func (m ReviewModel) GetAllForUser(userID int64) ([]*Review, error) {
+	query := `
+    SELECT reviews.id, reviews.created_at, reviews.movie_id, reviews.user_id,
+      users.name, reviews.score, reviews.text, reviews.version
+    FROM reviews
+    INNER JOIN users ON users.id = reviews.user_id
+    WHERE reviews.user_id = $1
+    ORDER BY reviews.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Author,
+			&review.Score,
+			&review.Text,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// Update() changes the score and text of a review, using the version number to
+// prevent edit conflicts.
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+    UPDATE reviews
+    SET score = $1, text = $2, version = version + 1
+    WHERE id = $3 AND version = $4
+    RETURNING version`
+
+	args := []any{review.Score, review.Text, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() deletes a specific review.
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM reviews
+    WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
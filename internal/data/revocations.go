@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RevocationRetention is how long revocations are kept for. Signed access
+// tokens can't be revoked by deleting them, so instead they are checked
+// against a denylist of revocations. A revocation only needs to be kept until
+// every token that it applies to has expired, so signed access tokens must
+// not live longer than this.
+const RevocationRetention = 24 * time.Hour
+
+// Define a Revocation struct. If AllSessions is false, the revocation applies
+// to a single session: the SessionID is the ID of the session's authentication
+// token, which is also the "jti" claim of its signed access tokens. Refreshing
+// a session gives it a new authentication token with a new ID, so the
+// session's token Family is recorded too, which covers all of them. If
+// AllSessions is true, it applies to every one of the user's sessions with an
+// ID up to and including SessionID. Because token IDs only ever increase, this
+// revokes every session that existed at the time, without having to compare
+// timestamps.
+type Revocation struct {
+	ID          int64
+	UserID      int64
+	SessionID   int64
+	AllSessions bool
+	Family      string
+	Expiry      time.Time
+}
+
+// Define the RevocationModel type.
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+// RevokeSession() revokes the signed access tokens for a single session, and
+// for every other session in the same token family. If only the family is
+// known, the session ID can be 0.
+func (m RevocationModel) RevokeSession(userID, sessionID int64, family string) (*Revocation, error) {
+	query := `
+    INSERT INTO token_revocations (user_id, session_id, family, expiry)
+    VALUES ($1, $2, $3, $4)
+    RETURNING id, session_id, all_sessions, family, expiry`
+
+	return m.insert(query, userID, sessionID, family)
+}
+
+// RevokeUser() revokes the signed access tokens for every session that a user
+// has had so far.
+func (m RevocationModel) RevokeUser(userID int64) (*Revocation, error) {
+	query := `
+    INSERT INTO token_revocations (user_id, session_id, all_sessions, expiry)
+    SELECT $1, COALESCE(max(id), 0), true, $2 FROM tokens
+    RETURNING id, session_id, all_sessions, family, expiry`
+
+	return m.insert(query, userID)
+}
+
+// The insert() helper runs one of the insert queries above. The query
+// placeholders are the user ID, then any extra arguments, then the expiry.
+func (m RevocationModel) insert(query string, userID int64, extra ...any) (*Revocation, error) {
+	revocation := &Revocation{UserID: userID}
+
+	args := append([]any{userID}, extra...)
+	args = append(args, time.Now().Add(RevocationRetention))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&revocation.ID,
+		&revocation.SessionID,
+		&revocation.AllSessions,
+		&revocation.Family,
+		&revocation.Expiry,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return revocation, nil
+}
+
+// GetAll() returns every unexpired revocation. Because revocations are only
+// kept for as long as the tokens that they apply to could be valid, there are
+// never very many of them.
+func (m RevocationModel) GetAll() ([]*Revocation, error) {
+	query := `
+    SELECT id, user_id, session_id, all_sessions, family, expiry
+    FROM token_revocations
+    WHERE expiry > $1
+    ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := []*Revocation{}
+
+	for rows.Next() {
+		var revocation Revocation
+
+		err := rows.Scan(
+			&revocation.ID,
+			&revocation.UserID,
+			&revocation.SessionID,
+			&revocation.AllSessions,
+			&revocation.Family,
+			&revocation.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, &revocation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// DeleteExpired() deletes revocations which are no longer needed.
+func (m RevocationModel) DeleteExpired() (int64, error) {
+	query := `
+    DELETE FROM token_revocations
+    WHERE expiry < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
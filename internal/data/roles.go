@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a Role struct. A role is a named bundle of permissions (like "viewer"
+// or "editor") which can be given to users, rather than granting them each
+// permission code individually.
+type Role struct {
+	ID          int64       `json:"-"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// Define the RoleModel type.
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// Get() retrieves a role by name, along with the permissions that it grants.
+func (m RoleModel) Get(name string) (*Role, error) {
+	query := `
+    SELECT roles.id, roles.name,
+      array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+    FROM roles
+    LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+    LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+    WHERE roles.name = $1
+    GROUP BY roles.id`
+
+	var role Role
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(
+		&role.ID,
+		&role.Name,
+		pq.Array(&role.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// GetAllForUser() returns the names of the roles that a user has.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+    SELECT roles.name
+    FROM roles
+    INNER JOIN users_roles ON users_roles.role_id = roles.id
+    WHERE users_roles.user_id = $1
+    ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AddForUser() gives a user the provided roles. Roles that the user already
+// has are ignored.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+    INSERT INTO users_roles
+    SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+    ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+// RemoveForUser() takes the provided roles away from a user.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+    DELETE FROM users_roles
+    USING roles
+    WHERE users_roles.role_id = roles.id
+    AND users_roles.user_id = $1
+    AND roles.name = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
@@ -0,0 +1,151 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a Session struct to represent a live authentication token, as shown
+// to the user who owns it. Importantly, this never contains the token hash or
+// plaintext, only the non-secret ID which can be used to revoke it. The
+// Current field is set to true for the session that made the request, and the
+// Permissions field is only set for scoped sessions.
+type Session struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	Expiry      time.Time   `json:"expiry"`
+	IP          string      `json:"ip"`
+	UserAgent   string      `json:"user_agent"`
+	Permissions Permissions `json:"permissions,omitzero"`
+	Current     bool        `json:"current"`
+}
+
+// The GetAllSessionsForUser() method returns all unexpired authentication
+// tokens for a specific user as sessions, most recently used first. The
+// currentToken parameter is the plaintext token used by the current request,
+// which is used to work out which session is the current one.
+func (m TokenModel) GetAllSessionsForUser(
+	userID int64,
+	currentToken string,
+) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentToken))
+
+	query := `
+    SELECT tokens.id, tokens.created_at, tokens.last_used_at, tokens.expiry,
+      tokens.ip, tokens.user_agent, tokens.scoped,
+      array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL),
+      tokens.hash = $3
+    FROM tokens
+    LEFT JOIN tokens_permissions ON tokens_permissions.token_id = tokens.id
+    LEFT JOIN permissions ON tokens_permissions.permission_id = permissions.id
+    WHERE tokens.user_id = $1 AND tokens.scope = $2 AND tokens.expiry > NOW()
+    GROUP BY tokens.id
+    ORDER BY COALESCE(tokens.last_used_at, tokens.created_at) DESC, tokens.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication,
+		currentHash[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		var scoped bool
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&scoped,
+			pq.Array(&session.Permissions),
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Leave the permissions nil for unscoped sessions, so that they're
+		// left out of the JSON, but not for scoped ones which have lost all
+		// of their permissions.
+		switch {
+		case !scoped:
+			session.Permissions = nil
+		case session.Permissions == nil:
+			session.Permissions = Permissions{}
+		}
+
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// The DeleteSessionForUser() method revokes a single session by its ID, along
+// with the refresh token that was issued alongside it. The user ID is included
+// in the WHERE clause so that users can only ever revoke their own sessions.
+// The session's token family is returned, so that its signed access tokens can
+// be revoked too. If no matching session exists, an ErrRecordNotFound error is
+// returned.
+func (m TokenModel) DeleteSessionForUser(id, userID int64) (string, error) {
+	if id < 1 {
+		return "", ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM tokens
+    WHERE user_id = $2 AND (
+      (id = $1 AND scope = $3)
+      OR family IN (
+        SELECT family FROM tokens WHERE id = $1 AND scope = $3 AND family <> ''
+      )
+    )
+    RETURNING family`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, userID, ScopeAuthentication)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	// Every token which was deleted belongs to the same family, so it doesn't
+	// matter which row the family is read from.
+	found := false
+	var family string
+
+	for rows.Next() {
+		err := rows.Scan(&family)
+		if err != nil {
+			return "", err
+		}
+
+		found = true
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	if !found {
+		return "", ErrRecordNotFound
+	}
+
+	return family, nil
+}
@@ -5,27 +5,54 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"time"
+	"unicode/utf8"
 
 	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/lib/pq"
 )
 
-// Define constants for the token scope. For now we just define the scope
-// "activation" but we'll add additional scopes later in the book.
+// Define constants for the token scope.
 const (
-	ScopeActivation     = "activation"
-	ScopeAuthentication = "authentication"
+	ScopeActivation      = "activation"
+	ScopeAuthentication  = "authentication"
+	ScopePasswordReset   = "password-reset"
+	ScopeRefresh         = "refresh"
+	ScopeMFA             = "mfa"
+	ScopeEmailChange     = "email-change"
+	ScopeAccountDeletion = "account-deletion"
+)
+
+// Define a custom ErrTokenReused error. This is returned when a refresh token
+// which has already been exchanged is presented again, which suggests that it
+// has been stolen.
+var (
+	ErrTokenReused = errors.New("token reused")
 )
 
 // Define a Token struct to hold the data for an individual token. This
 // includes the plaintext and hashed versions of the token, associated user ID,
-// expiry time and scope.
+// expiry time and scope, along with some metadata about the client that the
+// token was issued to. The ID is a non-secret identifier which can safely be
+// shown to users and used to refer to the token. Authentication and refresh
+// tokens which were issued together share the same Family, so that they can
+// be revoked together. A scoped token is restricted to the subset of the
+// user's permissions in its Permissions field; for other tokens this is nil.
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID          int64       `json:"-"`
+	Plaintext   string      `json:"token"`
+	Hash        []byte      `json:"-"`
+	UserID      int64       `json:"-"`
+	CreatedAt   time.Time   `json:"-"`
+	LastUsedAt  *time.Time  `json:"-"`
+	Expiry      time.Time   `json:"expiry"`
+	Scope       string      `json:"-"`
+	IP          string      `json:"-"`
+	UserAgent   string      `json:"-"`
+	Family      string      `json:"-"`
+	Permissions Permissions `json:"permissions,omitempty"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) *Token {
@@ -73,21 +100,249 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// The NewPair() method creates a new authentication token and refresh token
+// for a user, recording the IP address and User-Agent of the client that they
+// are being issued to. Both tokens belong to the same token family. If the
+// family parameter is the empty string, then a new family is started.
+//
+// If permissions is not nil, then both tokens are scoped: they are restricted
+// to those permissions, and so is every token pair issued from the refresh
+// token later on. If refreshTTL is zero, then no refresh token is issued, and
+// nil is returned in its place.
+func (m TokenModel) NewPair(
+	userID int64,
+	accessTTL, refreshTTL time.Duration,
+	family, ip, userAgent string,
+	permissions Permissions,
+) (*Token, *Token, error) {
+	if family == "" {
+		family = rand.Text()
+	}
+
+	access := generateToken(userID, accessTTL, ScopeAuthentication)
+	tokens := []*Token{access}
+
+	var refresh *Token
+
+	if refreshTTL > 0 {
+		refresh = generateToken(userID, refreshTTL, ScopeRefresh)
+		tokens = append(tokens, refresh)
+	}
+
+	for _, token := range tokens {
+		token.Family = family
+		token.IP = ip
+		token.UserAgent = truncate(userAgent, 500)
+		token.Permissions = permissions
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	for _, token := range tokens {
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if permissions == nil {
+			continue
+		}
+
+		query := `
+      INSERT INTO tokens_permissions
+      SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, token.ID, pq.Array(permissions))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+// The insertToken() helper inserts a token using either the connection pool
+// or a transaction, since both *sql.DB and *sql.Tx implement QueryRowContext().
+func insertToken(
+	ctx context.Context,
+	db interface {
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	},
+	token *Token,
+) error {
+	query := `
+    INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family,
+      scoped)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    RETURNING id, created_at`
+
+	args := []any{
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		token.IP,
+		token.UserAgent,
+		token.Family,
+		token.Permissions != nil,
+	}
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&token.ID,
+		&token.CreatedAt)
+}
+
+// The UseRefreshToken() method marks an unexpired refresh token as used and
+// returns it, so that it can be exchanged for a new token pair. The update is
+// atomic, so a refresh token can only ever be used once even if it's presented
+// by two requests at the same time. When a token is used, the authentication
+// tokens previously issued in its family are deleted, since the client is about
+// to be given a new one.
+//
+// If the refresh token has already been used, we assume that it has been
+// stolen: every token in its family is revoked and ErrTokenReused is returned,
+// along with a token holding just the user ID and family, so that the caller
+// can revoke anything else that was issued to the family.
+func (m TokenModel) UseRefreshToken(tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    UPDATE tokens
+    SET used_at = NOW()
+    WHERE hash = $1 AND scope = $2 AND expiry > $3 AND used_at IS NULL
+    RETURNING id, user_id, created_at, expiry, ip, user_agent, family`
+
+	token := Token{Hash: tokenHash[:], Scope: ScopeRefresh}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh,
+		time.Now()).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.CreatedAt,
+		&token.Expiry,
+		&token.IP,
+		&token.UserAgent,
+		&token.Family,
+	)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// The token wasn't available for use. Check whether that's because it
+		// has already been used, and if so revoke the whole family.
+		query = `
+      SELECT user_id, family
+      FROM tokens
+      WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL`
+
+		err = m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(
+			&token.UserID,
+			&token.Family,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+			default:
+				return nil, err
+			}
+		}
+
+		err = m.DeleteFamily(token.Family)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Token{UserID: token.UserID, Family: token.Family}, ErrTokenReused
+	}
+
+	query = `
+    DELETE FROM tokens
+    WHERE family = $1 AND scope = $2`
+
+	_, err = m.DB.ExecContext(ctx, query, token.Family, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// DeleteFamily() deletes every token in a token family.
+func (m TokenModel) DeleteFamily(family string) error {
+	// Tokens which aren't part of a family have an empty family value, and
+	// these must never be deleted together.
+	if family == "" {
+		return nil
+	}
+
 	query := `
-    INSERT INTO tokens (hash, user_id, expiry, scope)
-    VALUES ($1, $2, $3, $4)`
+    DELETE FROM tokens
+    WHERE family = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
+
+// Touch() records that a token has just been used. To avoid writing to the
+// database on every single request, the last_used_at timestamp is only
+// updated if it is more than a minute old.
+func (m TokenModel) Touch(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    UPDATE tokens
+    SET last_used_at = NOW()
+    WHERE hash = $1 AND scope = $2
+    AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
 	return err
 }
 
+// truncate() shortens s to at most n bytes, taking care not to split a
+// multi-byte UTF-8 character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
 // DeleteAllForUser() deletes all tokens for a specific user and scope.
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
@@ -100,3 +355,83 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllSessionsForUser() deletes all authentication and refresh tokens for
+// a specific user, logging them out everywhere.
+func (m TokenModel) DeleteAllSessionsForUser(userID int64) error {
+	query := `
+    DELETE FROM tokens
+    WHERE scope IN ($1, $2) AND user_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ScopeAuthentication, ScopeRefresh,
+		userID)
+	return err
+}
+
+// DeleteAllScopesForUser() deletes every token for a specific user, whatever
+// its scope.
+func (m TokenModel) DeleteAllScopesForUser(userID int64) error {
+	query := `
+    DELETE FROM tokens
+    WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteExpired() deletes every expired token, whatever its scope, and returns
+// the number of tokens deleted.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+    DELETE FROM tokens
+    WHERE expiry < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Delete() deletes a single token with a specific scope, identified by its
+// plaintext value, along with any other tokens in the same family. If no
+// matching token exists, an ErrRecordNotFound error is returned.
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+    DELETE FROM tokens
+    WHERE (hash = $1 AND scope = $2)
+    OR family IN (
+      SELECT family FROM tokens WHERE hash = $1 AND scope = $2 AND family <> ''
+    )`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/totp"
+)
+
+// Define a TOTP struct to hold a user's two-factor authentication settings. A
+// secret is stored as soon as the user starts enrolling, but Enabled is only
+// set to true once they've confirmed it with a valid code. LastUsedStep holds
+// the time step of the most recently accepted code, so that a code can't be
+// used twice.
+type TOTP struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
+
+// Define the TOTPModel type.
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// Enroll() stores a new, not-yet-enabled secret for a user, replacing any
+// previous pending secret. If the user already has two-factor authentication
+// enabled then nothing is changed and an ErrEditConflict error is returned.
+func (m TOTPModel) Enroll(userID int64, secret string) error {
+	query := `
+    INSERT INTO users_totp (user_id, secret)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE
+    SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+    WHERE users_totp.enabled = false`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// GetForUser() returns the two-factor authentication settings for a user, or
+// ErrRecordNotFound if they have never started enrolling.
+func (m TOTPModel) GetForUser(userID int64) (*TOTP, error) {
+	query := `
+    SELECT user_id, created_at, secret, enabled, last_used_step
+    FROM users_totp
+    WHERE user_id = $1`
+
+	var t TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&t.UserID,
+		&t.CreatedAt,
+		&t.Secret,
+		&t.Enabled,
+		&t.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// IsEnabled() is a shortcut which reports whether a user has two-factor
+// authentication enabled.
+func (m TOTPModel) IsEnabled(userID int64) (bool, error) {
+	t, err := m.GetForUser(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return t.Enabled, nil
+}
+
+// Verify() checks a code against the user's secret. A code is only accepted
+// if it is for a later time step than the last accepted code, and the update
+// of last_used_step is atomic, so each code can only ever be used once.
+func (m TOTPModel) Verify(t *TOTP, code string) (bool, error) {
+	step, ok, err := totp.Validate(t.Secret, code, time.Now(), 1, t.LastUsedStep)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	query := `
+    UPDATE users_totp
+    SET last_used_step = $1
+    WHERE user_id = $2 AND last_used_step < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, step, t.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	t.LastUsedStep = step
+
+	return true, nil
+}
+
+// Enable() turns on two-factor authentication for a user and generates a
+// fresh set of recovery codes, returning their plaintext values. Both happen
+// in a single transaction.
+func (m TOTPModel) Enable(userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+    UPDATE users_totp
+    SET enabled = true
+    WHERE user_id = $1 AND enabled = false`
+
+	result, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+
+	query = `
+    DELETE FROM recovery_codes
+    WHERE user_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+    INSERT INTO recovery_codes (user_id, hash)
+    VALUES ($1, $2)`
+
+	codes := make([]string, 10)
+
+	for i := range codes {
+		codes[i] = generateRecoveryCode()
+		hash := hashRecoveryCode(codes[i])
+
+		_, err = tx.ExecContext(ctx, query, userID, hash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable() turns off two-factor authentication for a user, deleting their
+// secret and recovery codes.
+func (m TOTPModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode() marks one of a user's unused recovery codes as used,
+// returning false if the code doesn't match any of them.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := hashRecoveryCode(code)
+
+	query := `
+    UPDATE recovery_codes
+    SET used_at = NOW()
+    WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Recovery codes are 10 random base32 characters, split into two groups of
+// five to make them easier to copy down, like "ABCDE-FGHIJ".
+func generateRecoveryCode() string {
+	s := rand.Text()[:10]
+	return s[:5] + "-" + s[5:]
+}
+
+// Recovery codes are normalized before hashing, so that users can enter them
+// without the hyphen or in lowercase.
+func hashRecoveryCode(code string) [32]byte {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	return sha256.Sum256([]byte(code))
+}
@@ -5,11 +5,12 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/kjloveless/greenlight/internal/hasher"
 	"github.com/kjloveless/greenlight/internal/validator"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Define a custom ErrDuplicateEmail error.
@@ -44,20 +45,53 @@ type UserModel struct {
 	DB *sql.DB
 }
 
+// The passwordHashers set holds the algorithm used to hash new passwords
+// (Argon2id), along with the legacy bcrypt algorithm that older passwords were
+// hashed with. Stored hashes made by any of these can still be verified.
+var passwordHashers = hasher.Set{
+	Current: hasher.DefaultArgon2id,
+	Legacy:  []hasher.Hasher{hasher.Bcrypt{Cost: 12}},
+}
+
+// The dummyPasswordHash() function returns the hash of a fixed password, made
+// with the current hashing algorithm. It's only worked out the first time it's
+// needed.
+var dummyPasswordHash = sync.OnceValues(func() ([]byte, error) {
+	return passwordHashers.Hash("greenlight dummy password")
+})
+
+// VerifyDummyPassword() checks a plaintext password against a fixed hash, in
+// exactly the same way as Matches() checks a real one, and throws the result
+// away. It's used when someone tries to log in to an account which doesn't
+// exist, so that the response takes as long as it would for a real account
+// and doesn't reveal which email addresses have accounts.
+func VerifyDummyPassword(plaintextPassword string) error {
+	hash, err := dummyPasswordHash()
+	if err != nil {
+		return err
+	}
+
+	_, err = passwordHashers.Verify(plaintextPassword, hash)
+	return err
+}
+
 // Create a custome password type which is a struct containing the plaintext
 // and hashed versions of the password for a user. The plaintext field is a
 // *pointer* to a string, so that we're able to distinguish between a plaintext
 // password not being present in the struct at all, versus a plaintext password
-// which is the empty string "".
+// which is the empty string "". The rehashed field records whether the hash
+// was upgraded by the Matches() method, and so needs saving.
 type password struct {
 	plaintext *string
 	hash      []byte
+	rehashed  bool
 }
 
-// The Set() method calculates the bcrypt hash of a plaintext password, and
-// stores both the hash and the plaintext version in the struct.
+// The Set() method calculates the hash of a plaintext password using the
+// current hashing algorithm, and stores both the hash and the plaintext
+// version in the struct.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := passwordHashers.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -70,21 +104,35 @@ func (p *password) Set(plaintextPassword string) error {
 
 // The Matches() method checks whether the provided plaintext password matches
 // the hashed password stored in the struct, returning true if it matches and
-// false otherwise.
+// false otherwise. If the password matches but the stored hash was made with
+// an old algorithm or old parameters, then the hash is transparently upgraded
+// in the struct and Rehashed() will return true. It's up to the caller to save
+// the user record in that case.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
+	match, err := passwordHashers.Verify(plaintextPassword, p.hash)
+	if err != nil || !match {
+		return false, err
+	}
+
+	if passwordHashers.NeedsRehash(p.hash) {
+		hash, err := passwordHashers.Hash(plaintextPassword)
+		if err != nil {
 			return false, err
 		}
+
+		p.hash = hash
+		p.rehashed = true
 	}
 
 	return true, nil
 }
 
+// The Rehashed() method reports whether the password hash was upgraded by a
+// call to Matches() and needs to be saved.
+func (p *password) Rehashed() bool {
+	return p.rehashed
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -93,7 +141,7 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= 256, "password", "must not be more than 256 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -104,9 +152,10 @@ func ValidateUser(v *validator.Validator, user *User) {
 	ValidateEmail(v, user.Email)
 
 	// If the plaintext password is not nil, call the standalone
-	// ValidatePasswordPlaintext() helper.
+	// ValidatePasswordPlaintext() and ValidatePasswordStrength() helpers.
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidatePasswordStrength(v, *user.Password.plaintext, user.Email, user.Name)
 	}
 
 	// If the password hash is ever nil, this will be due to a logic error in our
@@ -153,6 +202,98 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Retrieve the User details from the database based on the user's ID.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, created_at, name, email, password_hash, activated, version
+    FROM users
+    WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAll() returns a paginated list of users, optionally filtered by name and
+// email address. The name is matched using full-text search in the same way as
+// movie titles, and the email filter matches any address which contains it.
+func (m UserModel) GetAll(name, email string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), id, created_at, name, email, password_hash,
+      activated, version
+    FROM users
+    WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+    AND (strpos(lower(email), lower($2)) > 0 OR $2 = '')
+    ORDER BY %s %s, id ASC
+    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{name, email, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
 // Retrieve the User details from the database based on the user's email
 // address. Because we have a UNIQUE constraint on the email column, this SQL
 // query will only return one record (or none at all, in which case we return a
@@ -217,7 +358,7 @@ func (m UserModel) Update(user *User) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key violates unique constraint "users_email_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
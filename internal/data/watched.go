@@ -0,0 +1,249 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Define a WatchedEntry struct for a movie in a user's watched history, along
+// with the date that they watched it and any notes that they added.
+type WatchedEntry struct {
+	UserID    int64  `json:"-"`
+	Movie     *Movie `json:"movie"`
+	Notes     string `json:"notes,omitzero"`
+	WatchedOn Date   `json:"watched_on"`
+}
+
+func ValidateWatchedEntry(v *validator.Validator, entry *WatchedEntry) {
+	ValidateListNotes(v, entry.Notes)
+
+	v.Check(!entry.WatchedOn.IsZero(), "watched_on", "must be provided")
+	v.Check(entry.WatchedOn.Year() >= 1888, "watched_on", "must be greater than 1888")
+	v.Check(!entry.WatchedOn.After(time.Now()), "watched_on", "must not be in the future")
+}
+
+// Define the WatchedModel type.
+type WatchedModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a movie to a user's watched history. If it's already there, an
+// ErrDuplicateListEntry error is returned.
+func (m WatchedModel) Insert(entry *WatchedEntry) error {
+	query := `
+    INSERT INTO watched (user_id, movie_id, notes, watched_on)
+    VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, entry.UserID, entry.Movie.ID,
+		entry.Notes, entry.WatchedOn.Format(time.DateOnly))
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "watched_pkey"`:
+			return ErrDuplicateListEntry
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get() retrieves the watched history entry for a movie. If the movie isn't in
+// the user's watched history, an ErrRecordNotFound error is returned.
+func (m WatchedModel) Get(userID int64, movie *Movie) (*WatchedEntry, error) {
+	query := `
+    SELECT notes, watched_on
+    FROM watched
+    WHERE user_id = $1 AND movie_id = $2`
+
+	entry := WatchedEntry{
+		UserID: userID,
+		Movie:  movie,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movie.ID).Scan(
+		&entry.Notes,
+		&entry.WatchedOn.Time,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// GetAllForUser() returns a page of the movies in a user's watched history which
+// are in a specific organization's catalog, along with the pagination
+// metadata.
+func (m WatchedModel) GetAllForUser(
+	userID, organizationID int64,
+	filters Filters,
+) ([]*WatchedEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), movies.id, movies.organization_id,
+      movies.created_at, movies.title, movies.year, movies.runtime,
+      movies.genres, average_rating, rating_count, collection, movies.version,
+      watched.user_id, watched.notes, watched.watched_on
+    FROM watched
+    INNER JOIN movies ON movies.id = watched.movie_id`+movieRatings+movieCollection+`
+    WHERE watched.user_id = $1 AND movies.organization_id = $2
+    ORDER BY %s %s, movies.id ASC
+    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{userID, organizationID, filters.limit(), filters.offset()}
+
+	entries, totalRecords, err := m.query(query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// GetAllForExport() returns every entry in a user's watched history, from every
+// organization, for the user's data export.
+func (m WatchedModel) GetAllForExport(userID int64) ([]*WatchedEntry, error) {
+	query := `
+    SELECT count(*) OVER(), movies.id, movies.organization_id,
+      movies.created_at, movies.title, movies.year, movies.runtime,
+      movies.genres, average_rating, rating_count, collection, movies.version,
+      watched.user_id, watched.notes, watched.watched_on
+    FROM watched
+    INNER JOIN movies ON movies.id = watched.movie_id` + movieRatings + movieCollection + `
+    WHERE watched.user_id = $1
+    ORDER BY watched.watched_on, movies.id`
+
+	entries, _, err := m.query(query, userID)
+	return entries, err
+}
+
+// query() runs one of the queries above and scans the resulting entries, along
+// with the total record count from the count(*) OVER() column.
+func (m WatchedModel) query(query string, args ...any) ([]*WatchedEntry, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchedEntry{}
+
+	for rows.Next() {
+		var movie Movie
+		var entry WatchedEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.OrganizationID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Collection,
+			&movie.Version,
+			&entry.UserID,
+			&entry.Notes,
+			&entry.WatchedOn.Time,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		entry.Movie = &movie
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, totalRecords, nil
+}
+
+// Update() changes the notes and watched date of a watched history entry.
+func (m WatchedModel) Update(entry *WatchedEntry) error {
+	query := `
+    UPDATE watched
+    SET notes = $1, watched_on = $2
+    WHERE user_id = $3 AND movie_id = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		entry.Notes,
+		entry.WatchedOn.Format(time.DateOnly),
+		entry.UserID,
+		entry.Movie.ID,
+	}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete() removes a movie from a user's watched history.
+func (m WatchedModel) Delete(userID, movieID int64) error {
+	query := `
+    DELETE FROM watched
+    WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kjloveless/greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrDuplicateListEntry error, which is returned when a user
+// tries to add a movie to one of their lists when it's already on it.
+var ErrDuplicateListEntry = errors.New("duplicate list entry")
+
+// Define a WatchlistEntry struct for a movie on a user's watchlist, along with
+// any notes that they added.
+type WatchlistEntry struct {
+	UserID  int64     `json:"-"`
+	Movie   *Movie    `json:"movie"`
+	Notes   string    `json:"notes,omitzero"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+func ValidateListNotes(v *validator.Validator, notes string) {
+	v.Check(len(notes) <= 1000, "notes", "must not be more than 1000 bytes long")
+}
+
+// Define the WatchlistModel type.
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a movie to a user's watchlist. If it's already on the
+// watchlist, an ErrDuplicateListEntry error is returned.
+func (m WatchlistModel) Insert(entry *WatchlistEntry) error {
+	query := `
+    INSERT INTO watchlist (user_id, movie_id, notes)
+    VALUES ($1, $2, $3)
+    RETURNING added_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, entry.UserID, entry.Movie.ID,
+		entry.Notes).Scan(&entry.AddedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "watchlist_pkey"`:
+			return ErrDuplicateListEntry
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get() retrieves the watchlist entry for a movie. If the movie isn't on the
+// user's watchlist, an ErrRecordNotFound error is returned.
+func (m WatchlistModel) Get(userID int64, movie *Movie) (*WatchlistEntry, error) {
+	query := `
+    SELECT notes, added_at
+    FROM watchlist
+    WHERE user_id = $1 AND movie_id = $2`
+
+	entry := WatchlistEntry{
+		UserID: userID,
+		Movie:  movie,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movie.ID).Scan(
+		&entry.Notes,
+		&entry.AddedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// GetAllForUser() returns a page of the movies on a user's watchlist which are
+// in a specific organization's catalog, along with the pagination metadata.
+func (m WatchlistModel) GetAllForUser(
+	userID, organizationID int64,
+	filters Filters,
+) ([]*WatchlistEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+    SELECT count(*) OVER(), movies.id, movies.organization_id,
+      movies.created_at, movies.title, movies.year, movies.runtime,
+      movies.genres, average_rating, rating_count, collection, movies.version,
+      watchlist.user_id, watchlist.notes, watchlist.added_at
+    FROM watchlist
+    INNER JOIN movies ON movies.id = watchlist.movie_id`+movieRatings+movieCollection+`
+    WHERE watchlist.user_id = $1 AND movies.organization_id = $2
+    ORDER BY %s %s, movies.id ASC
+    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{userID, organizationID, filters.limit(), filters.offset()}
+
+	entries, totalRecords, err := m.query(query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// GetAllForExport() returns every entry on a user's watchlist, from every
+// organization, for the user's data export.
+func (m WatchlistModel) GetAllForExport(userID int64) ([]*WatchlistEntry, error) {
+	query := `
+    SELECT count(*) OVER(), movies.id, movies.organization_id,
+      movies.created_at, movies.title, movies.year, movies.runtime,
+      movies.genres, average_rating, rating_count, collection, movies.version,
+      watchlist.user_id, watchlist.notes, watchlist.added_at
+    FROM watchlist
+    INNER JOIN movies ON movies.id = watchlist.movie_id` + movieRatings + movieCollection + `
+    WHERE watchlist.user_id = $1
+    ORDER BY watchlist.added_at, movies.id`
+
+	entries, _, err := m.query(query, userID)
+	return entries, err
+}
+
+// query() runs one of the queries above and scans the resulting entries, along
+// with the total record count from the count(*) OVER() column.
+func (m WatchlistModel) query(query string, args ...any) ([]*WatchlistEntry, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		var movie Movie
+		var entry WatchlistEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.OrganizationID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Collection,
+			&movie.Version,
+			&entry.UserID,
+			&entry.Notes,
+			&entry.AddedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		entry.Movie = &movie
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, totalRecords, nil
+}
+
+// Update() changes the notes on a watchlist entry.
+func (m WatchlistModel) Update(entry *WatchlistEntry) error {
+	query := `
+    UPDATE watchlist
+    SET notes = $1
+    WHERE user_id = $2 AND movie_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, entry.Notes, entry.UserID,
+		entry.Movie.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete() removes a movie from a user's watchlist.
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	query := `
+    DELETE FROM watchlist
+    WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
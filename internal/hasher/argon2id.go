@@ -0,0 +1,148 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var errInvalidArgon2idHash = errors.New("hasher: invalid argon2id hash")
+
+// Argon2id hashes passwords using the Argon2id algorithm from RFC 9106. The
+// hashes are encoded in the PHC string format used by the reference
+// implementation, for example:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<base64 salt>$<base64 key>
+type Argon2id struct {
+	Memory      uint32 // Memory cost in KiB.
+	Iterations  uint32 // Number of passes over the memory.
+	Parallelism uint8  // Number of threads (lanes).
+	SaltLength  uint32 // Length of the random salt in bytes.
+	KeyLength   uint32 // Length of the derived key in bytes.
+}
+
+// DefaultArgon2id holds the parameters we use for new passwords. These follow
+// the second recommended option in RFC 9106, section 4, scaled down to 64 MiB
+// of memory.
+var DefaultArgon2id = Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// The argon2idSlots channel is a semaphore which limits how many Argon2id keys
+// can be derived at once. Each one needs Memory KiB of RAM for as long as it
+// runs, so without a limit a burst of logins or registrations could use
+// gigabytes of memory before the rate limiter has a chance to step in.
+var argon2idSlots = make(chan struct{}, 4)
+
+// SetArgon2idConcurrency sets the maximum number of Argon2id keys which can be
+// derived at once; any more wait for a free slot. It isn't safe to call while
+// passwords are being hashed, so it should be called once at startup.
+func SetArgon2idConcurrency(n int) {
+	argon2idSlots = make(chan struct{}, max(n, 1))
+}
+
+// The idKey() function wraps argon2.IDKey(), waiting for a free slot first.
+func idKey(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	slots := argon2idSlots
+
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	return argon2.IDKey(password, salt, time, memory, threads, keyLen)
+}
+
+func (a Argon2id) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, a.SaltLength)
+	// crypto/rand.Read never returns an error.
+	rand.Read(salt)
+
+	key := idKey([]byte(plaintext), salt, a.Iterations, a.Memory,
+		a.Parallelism, a.KeyLength)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix,
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (a Argon2id) Verify(plaintext string, encoded []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := idKey([]byte(plaintext), salt, params.Iterations,
+		params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (a Argon2id) Identify(encoded []byte) bool {
+	return strings.HasPrefix(string(encoded), argon2idPrefix)
+}
+
+func (a Argon2id) NeedsRehash(encoded []byte) bool {
+	params, salt, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != a.Memory ||
+		params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism ||
+		params.KeyLength != a.KeyLength ||
+		uint32(len(salt)) != a.SaltLength
+}
+
+// The decodeArgon2id() function parses an encoded hash, returning the
+// parameters, salt and derived key that it contains.
+func decodeArgon2id(encoded []byte) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+
+	// Splitting "$argon2id$v=19$m=..,t=..,p=..$salt$key" on "$" gives an empty
+	// first element followed by five parts.
+	parts := strings.Split(string(encoded), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory,
+		&params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidArgon2idHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
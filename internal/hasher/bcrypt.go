@@ -0,0 +1,64 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt hashes passwords using bcrypt at the given cost. The hashes are in
+// the standard modular crypt format, like "$2a$12$...". Note that bcrypt only
+// uses the first 72 bytes of a password, and refuses to hash anything longer.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), b.Cost)
+}
+
+func (b Bcrypt) Verify(plaintext string, encoded []byte) (bool, error) {
+	// CompareHashAndPassword() silently ignores everything after the first 72
+	// bytes, so a longer password would match any password which starts with
+	// the same 72 bytes. No such password can have been hashed, so it can't
+	// be the right one.
+	if len(plaintext) > 72 {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(encoded, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (b Bcrypt) Identify(encoded []byte) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(string(encoded), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b Bcrypt) NeedsRehash(encoded []byte) bool {
+	if !b.Identify(encoded) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost(encoded)
+	if err != nil {
+		return true
+	}
+
+	return cost != b.Cost
+}
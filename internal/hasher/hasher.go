@@ -0,0 +1,63 @@
+// Package hasher provides password hashing algorithms behind a common
+// interface. Every hasher produces a self-describing encoded hash which
+// includes the name of the algorithm and the parameters used, so hashes made
+// with different algorithms (or different settings of the same algorithm) can
+// be stored side by side and verified later.
+package hasher
+
+import (
+	"errors"
+)
+
+// ErrUnknownAlgorithm is returned when an encoded hash wasn't produced by any
+// of the available hashers.
+var ErrUnknownAlgorithm = errors.New("hasher: unknown hash algorithm")
+
+// Hasher is the interface implemented by each password hashing algorithm.
+type Hasher interface {
+	// Hash returns the encoded hash of a plaintext password.
+	Hash(plaintext string) ([]byte, error)
+
+	// Verify reports whether a plaintext password matches an encoded hash.
+	Verify(plaintext string, encoded []byte) (bool, error)
+
+	// Identify reports whether an encoded hash was produced by this
+	// algorithm.
+	Identify(encoded []byte) bool
+
+	// NeedsRehash reports whether an encoded hash should be replaced because
+	// it wasn't produced by this hasher with its current parameters.
+	NeedsRehash(encoded []byte) bool
+}
+
+// Set holds the hasher used for new passwords, along with any legacy hashers
+// which are only used for verifying existing hashes.
+type Set struct {
+	Current Hasher
+	Legacy  []Hasher
+}
+
+// Hash returns the encoded hash of a plaintext password using the current
+// hasher.
+func (s Set) Hash(plaintext string) ([]byte, error) {
+	return s.Current.Hash(plaintext)
+}
+
+// Verify finds the hasher which produced an encoded hash and uses it to check
+// the plaintext password. If the encoded hash wasn't produced by any of the
+// hashers in the set, then ErrUnknownAlgorithm is returned.
+func (s Set) Verify(plaintext string, encoded []byte) (bool, error) {
+	for _, h := range append([]Hasher{s.Current}, s.Legacy...) {
+		if h.Identify(encoded) {
+			return h.Verify(plaintext, encoded)
+		}
+	}
+
+	return false, ErrUnknownAlgorithm
+}
+
+// NeedsRehash reports whether an encoded hash should be upgraded to the
+// current hasher and parameters.
+func (s Set) NeedsRehash(encoded []byte) bool {
+	return s.Current.NeedsRehash(encoded)
+}
@@ -0,0 +1,215 @@
+// Package jwt implements the small subset of JSON Web Tokens (RFC 7519) that
+// we use for stateless access tokens: compact-serialized tokens signed with
+// HMAC-SHA256 (HS256), with a key ID in the header so that signing keys can be
+// rotated. Like the totp package, every function that depends on the time
+// takes it as a parameter.
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MinKeySize is the minimum size of a signing key in bytes. RFC 7518 requires
+// HS256 keys to be at least as large as the hash output.
+const MinKeySize = 32
+
+var (
+	// ErrInvalidToken is returned (wrapped) when a token is malformed, has a
+	// bad signature, or has expired.
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	// ErrUnknownKey is returned (wrapped) when a token was signed with a key
+	// that the Signer doesn't know about.
+	ErrUnknownKey = errors.New("jwt: unknown key ID")
+)
+
+// Key is a named HMAC signing key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Claims holds the claims that we put in access tokens. Alongside the
+// registered claims, the token carries enough about the user (whether they are
+// activated, and their permissions) to authorize requests without looking
+// them up in the database. Scoped is true if the permissions have been
+// restricted to a subset of the user's permissions. Family is the token family
+// of the session, so that every token issued to a session by refreshing it
+// can be revoked at once.
+type Claims struct {
+	Issuer      string   `json:"iss"`
+	Subject     int64    `json:"sub,string"`
+	ID          int64    `json:"jti,string"`
+	IssuedAt    int64    `json:"iat"`
+	Expiry      int64    `json:"exp"`
+	Activated   bool     `json:"act"`
+	Permissions []string `json:"perms"`
+	Scoped      bool     `json:"scp,omitempty"`
+	Family      string   `json:"fam,omitempty"`
+}
+
+// Signer signs tokens with its current key, and verifies tokens signed with
+// any of its keys.
+type Signer struct {
+	issuer  string
+	current Key
+	keys    map[string][]byte
+}
+
+// NewSigner returns a Signer which signs new tokens with the first key, and
+// accepts tokens signed with any of the keys. Keeping a retired key in the
+// list for one token lifetime lets keys be rotated without logging anyone
+// out.
+func NewSigner(issuer string, keys ...Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: no signing keys")
+	}
+
+	s := &Signer{
+		issuer:  issuer,
+		current: keys[0],
+		keys:    make(map[string][]byte, len(keys)),
+	}
+
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("jwt: key ID must not be empty")
+		}
+		if len(key.Secret) < MinKeySize {
+			return nil, fmt.Errorf("jwt: key %q must be at least %d bytes", key.ID, MinKeySize)
+		}
+		if _, exists := s.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key ID %q", key.ID)
+		}
+
+		s.keys[key.ID] = key.Secret
+	}
+
+	return s, nil
+}
+
+// ParseKeys parses signing keys from a space-separated list of
+// "<id>:<base64 secret>" pairs, which is the format of the -jwt-keys flag.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+
+	for field := range strings.FieldsSeq(s) {
+		id, encoded, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("jwt: key %q is not in the form <id>:<secret>", field)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: secret is not valid base64", id)
+		}
+
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+
+	return keys, nil
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Sign returns a signed token for the claims, setting the issuer. The caller
+// is responsible for the other claims, including the expiry.
+func (s *Signer) Sign(claims Claims) (string, error) {
+	claims.Issuer = s.issuer
+
+	h, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT", KeyID: s.current.ID})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(h) + "." +
+		base64.RawURLEncoding.EncodeToString(c)
+
+	return unsigned + "." + sign(s.current.Secret, unsigned), nil
+}
+
+// Verify checks a token's signature, issuer and expiry at time now, and
+// returns its claims. Only HS256 is accepted; the algorithm in the header is
+// never trusted to choose how the token is verified.
+func (s *Signer) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var h header
+
+	err := decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	if h.Algorithm != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Algorithm)
+	}
+
+	secret, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, h.KeyID)
+	}
+
+	expected := sign(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	switch {
+	case claims.Issuer != s.issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	case !now.Before(time.Unix(claims.Expiry, 0)):
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	}
+
+	return &claims, nil
+}
+
+// IsJWT reports whether a bearer token looks like a JWT, as opposed to one of
+// our opaque tokens (which never contain dots).
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// sign returns the base64url-encoded HMAC-SHA256 of the input.
+func sign(secret []byte, input string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// decodeSegment decodes a base64url-encoded token segment into dst.
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
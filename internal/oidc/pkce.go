@@ -0,0 +1,27 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// RandomString returns a random, URL-safe string suitable for use as a state
+// value, nonce or PKCE code verifier. It contains 256 bits of randomness, and
+// at 43 characters it is within the 43-128 character range which RFC 7636
+// requires for code verifiers.
+func RandomString() string {
+	b := make([]byte, 32)
+	// crypto/rand.Read never returns an error.
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// S256Challenge returns the PKCE code challenge for a code verifier, using the
+// S256 method: BASE64URL(SHA256(verifier)).
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
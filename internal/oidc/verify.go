@@ -0,0 +1,237 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned (wrapped) by Verify when an ID token is
+// malformed, badly signed or fails any of the claim checks.
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+const (
+	// leeway is the clock skew that we allow when checking the exp and iat
+	// claims.
+	leeway = time.Minute
+	// minRefreshInterval stops a flood of tokens with unknown key IDs from
+	// making us hammer the provider's JWKS endpoint.
+	minRefreshInterval = time.Minute
+)
+
+// Claims holds the ID token claims that we care about.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// The aud claim can be either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+// Verify checks an ID token's signature against the provider's keys, then
+// checks that it was issued by this provider, for this client, with the
+// expected nonce, and that it hasn't expired at time now. Only RS256 is
+// accepted, which every OpenID provider is required to support; in particular
+// the "none" algorithm is always rejected.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string, now time.Time) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken,
+			header.Algorithm)
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	case !slices.Contains(claims.Audience, p.ClientID):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.ClientID:
+		return nil, fmt.Errorf("%w: wrong authorized party", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case now.After(time.Unix(claims.Expiry, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(leeway)):
+		return nil, fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidToken)
+	}
+
+	return &claims, nil
+}
+
+// key returns the provider's public key with the given key ID. If the key
+// isn't in the cache, then the JWKS is fetched again, since the provider may
+// have rotated its keys.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	if p.keys != nil && time.Since(p.keysFetched) < minRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalidToken, kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalidToken, kid)
+}
+
+// lookupKey finds a cached key. Tokens without a kid are only accepted if the
+// provider publishes exactly one key. The caller must hold p.mu.
+func (p *Provider) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetchKeys downloads the provider's JSON Web Key Set and returns its RSA
+// signing keys, indexed by key ID.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			Use     string `json:"use"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := getJSON(ctx, p.client, p.JWKSURI, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range jwks.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+		// Ignore keys which are too small to be trusted.
+		if key.N.BitLen() < 2048 {
+			continue
+		}
+
+		keys[k.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+// decodeSegment decodes a base64url-encoded JWT segment into dst.
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,145 @@
+// Package totp implements time-based one-time passwords as described in RFC
+// 6238, using the HMAC-SHA1 algorithm, 6-digit codes and a 30-second time step
+// (the defaults supported by all common authenticator apps). Every function
+// takes the current time as a parameter, so the package can be exercised
+// against the RFC test vectors without a real clock.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the number of digits in a generated code.
+	Digits = 6
+	// Period is the length of a single time step.
+	Period = 30 * time.Second
+	// secretSize is the size of a generated secret in bytes. RFC 4226
+	// recommends 160 bits, to match the output size of SHA-1.
+	secretSize = 20
+)
+
+// The secrets are encoded using unpadded base32, which is what authenticator
+// apps expect to see in an otpauth:// URI.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, encoded as base32.
+func GenerateSecret() string {
+	b := make([]byte, secretSize)
+	// crypto/rand.Read never returns an error.
+	rand.Read(b)
+
+	return encoding.EncodeToString(b)
+}
+
+// Step returns the time step number for the time t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for the given base32-encoded secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return code(key, Step(t)), nil
+}
+
+// Validate checks a code against the secret at time t. To allow for clock
+// drift between the server and the user's device, codes from up to skew time
+// steps either side of the current one are also accepted. Codes for time
+// steps at or before lastUsedStep are rejected, so that a code can't be used
+// twice. If the code is valid, the time step that it matched is returned,
+// which callers should record as the new lastUsedStep.
+func Validate(secret, passcode string, t time.Time, skew int, lastUsedStep int64) (int64, bool, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	passcode = strings.ReplaceAll(passcode, " ", "")
+	if len(passcode) != Digits {
+		return 0, false, nil
+	}
+
+	current := Step(t)
+
+	for i := -int64(skew); i <= int64(skew); i++ {
+		step := current + i
+		if step <= lastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(code(key, step)), []byte(passcode)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// URI returns an otpauth:// URI for the secret, which can be rendered as a QR
+// code and scanned by an authenticator app. The format is described at
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// The code() function implements the HOTP algorithm from RFC 4226, using the
+// time step as the counter.
+func code(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte give an offset into
+	// the HMAC, and the 31 bits starting at that offset are the code.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range Digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("totp: invalid secret: %w", err)
+	}
+
+	return key, nil
+}
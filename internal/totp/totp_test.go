@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The secret used by the RFC 6238 test vectors is the ASCII string
+// "12345678901234567890", encoded here as base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The SHA-1 test vectors from RFC 6238 Appendix B. The RFC gives 8-digit
+// codes, so only the last 6 digits are used here.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, tt := range rfcVectors {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): unexpected error: %v", tt.unix, err)
+		}
+
+		if got != tt.code {
+			t.Errorf("Code(%d) = %q; want %q", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	codeAt := func(step int64) string {
+		t.Helper()
+
+		code, err := Code(rfcSecret, time.Unix(step*int64(Period/time.Second), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return code
+	}
+
+	tests := []struct {
+		name         string
+		passcode     string
+		lastUsedStep int64
+		wantStep     int64
+		wantOK       bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"previous step", codeAt(current - 1), 0, current - 1, true},
+		{"next step", codeAt(current + 1), 0, current + 1, true},
+		{"two steps behind", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"spaces are ignored", codeAt(current)[:3] + " " + codeAt(current)[3:], 0, current, true},
+		{"wrong length", "12345", 0, 0, false},
+		{"replayed code", codeAt(current), current, 0, false},
+		{"code older than last used", codeAt(current - 1), current, 0, false},
+		{"code newer than last used", codeAt(current + 1), current, current + 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok, err := Validate(rfcSecret, tt.passcode, now, 1, tt.lastUsedStep)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate() = (%d, %t); want (%d, %t)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	_, _, err := Validate("not base32!", "123456", time.Now(), 1, 0)
+	if err == nil {
+		t.Error("expected an error for an invalid secret")
+	}
+}
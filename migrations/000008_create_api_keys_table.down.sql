@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS api_keys_permissions;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS unknown_login_failures;
+DROP TABLE IF EXISTS login_failures;
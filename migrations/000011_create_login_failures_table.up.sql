@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  failures integer NOT NULL DEFAULT 0,
+  last_failed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  locked_until timestamp(0) with time zone
+);
+
+-- Failed logins for email addresses which don't belong to any account are
+-- tracked too, so that they can be locked out in exactly the same way as real
+-- accounts and the lockout doesn't reveal which addresses have accounts.
+CREATE TABLE IF NOT EXISTS unknown_login_failures (
+  email citext PRIMARY KEY,
+  failures integer NOT NULL DEFAULT 0,
+  last_failed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  locked_until timestamp(0) with time zone
+);
@@ -0,0 +1,11 @@
+-- Turn the permissions that users have through their roles back into direct
+-- grants, so that nobody loses access when the roles are dropped.
+INSERT INTO users_permissions
+SELECT users_roles.user_id, roles_permissions.permission_id
+FROM users_roles
+INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
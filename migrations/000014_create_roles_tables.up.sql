@@ -0,0 +1,58 @@
+CREATE TABLE IF NOT EXISTS roles (
+  id bigserial PRIMARY KEY,
+  name text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+  role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+  PRIMARY KEY (user_id, role_id)
+);
+
+-- Add the built-in roles and the permissions that each one grants.
+INSERT INTO roles (name)
+VALUES
+  ('viewer'),
+  ('editor'),
+  ('admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.name = 'viewer' AND permissions.code = 'movies:read')
+OR (roles.name = 'editor' AND permissions.code IN ('movies:read', 'movies:write'))
+OR (roles.name = 'admin' AND permissions.code IN ('movies:read', 'movies:write'));
+
+-- Give existing users the role which matches the permissions that they were
+-- granted directly.
+INSERT INTO users_roles
+SELECT users_permissions.user_id, roles.id
+FROM users_permissions
+INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+INNER JOIN roles ON roles.name = 'editor'
+WHERE permissions.code = 'movies:write'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO users_roles
+SELECT users_permissions.user_id, roles.id
+FROM users_permissions
+INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+INNER JOIN roles ON roles.name = 'viewer'
+WHERE permissions.code = 'movies:read'
+AND users_permissions.user_id NOT IN (SELECT user_id FROM users_roles)
+ON CONFLICT DO NOTHING;
+
+-- The roles now grant these users the same permissions, so remove the direct
+-- grants which they cover. Otherwise revoking a role from a migrated user
+-- wouldn't take any of its permissions away.
+DELETE FROM users_permissions
+USING users_roles, roles_permissions
+WHERE users_roles.user_id = users_permissions.user_id
+AND roles_permissions.role_id = users_roles.role_id
+AND roles_permissions.permission_id = users_permissions.permission_id;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS token_revocations (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  session_id bigint NOT NULL,
+  family text NOT NULL DEFAULT '',
+  all_sessions bool NOT NULL DEFAULT false,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tokens_permissions;
+ALTER TABLE tokens DROP COLUMN IF EXISTS scoped;
@@ -0,0 +1,7 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS scoped boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS tokens_permissions (
+  token_id bigint NOT NULL REFERENCES tokens (id) ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (token_id, permission_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS invitations_permissions;
+DROP TABLE IF EXISTS invitations;
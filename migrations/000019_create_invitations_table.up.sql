@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS invitations (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  email citext NOT NULL,
+  hash bytea UNIQUE NOT NULL,
+  role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+  invited_by bigint REFERENCES users ON DELETE SET NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS invitations_email_idx ON invitations (email);
+
+CREATE TABLE IF NOT EXISTS invitations_permissions (
+  invitation_id bigint NOT NULL REFERENCES invitations ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (invitation_id, permission_id)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS movies_organization_id_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organization_memberships;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS organizations (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  name text NOT NULL,
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS organization_memberships (
+  organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  role text NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_memberships_user_id_idx ON organization_memberships (user_id);
+
+-- Move the existing catalog into a default organization. Every existing user
+-- becomes an editor of it, apart from users with the admin role, who become
+-- its admins.
+INSERT INTO organizations (name) VALUES ('Default');
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+
+UPDATE movies SET organization_id = (SELECT min(id) FROM organizations);
+
+ALTER TABLE movies ALTER COLUMN organization_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS movies_organization_id_idx ON movies (organization_id);
+
+INSERT INTO organization_memberships (organization_id, user_id, role)
+SELECT (SELECT min(id) FROM organizations), users.id,
+  CASE WHEN EXISTS (
+    SELECT 1 FROM users_roles
+    INNER JOIN roles ON roles.id = users_roles.role_id
+    WHERE users_roles.user_id = users.id AND roles.name = 'admin'
+  ) THEN 'admin' ELSE 'editor' END
+FROM users;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS reviews (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  score integer NOT NULL,
+  text text NOT NULL DEFAULT '',
+  version integer NOT NULL DEFAULT 1,
+  UNIQUE (movie_id, user_id)
+);
+
+ALTER TABLE reviews ADD CONSTRAINT reviews_score_check CHECK (score BETWEEN 1 AND 10);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
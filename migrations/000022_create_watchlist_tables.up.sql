@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS watchlist (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  notes text NOT NULL DEFAULT '',
+  added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_movie_id_idx ON watchlist (movie_id);
+
+CREATE TABLE IF NOT EXISTS watched (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  notes text NOT NULL DEFAULT '',
+  watched_on date NOT NULL DEFAULT CURRENT_DATE,
+  PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watched_movie_id_idx ON watched (movie_id);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS people (
+  id bigserial PRIMARY KEY,
+  organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  name text NOT NULL,
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_organization_id_idx ON people (organization_id);
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS credits (
+  id bigserial PRIMARY KEY,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+  role text NOT NULL,
+  character text NOT NULL DEFAULT '',
+  UNIQUE (movie_id, person_id, role, character)
+);
+
+CREATE INDEX IF NOT EXISTS credits_person_id_role_idx ON credits (person_id, role);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS collections_movies;
+DROP TABLE IF EXISTS collections;
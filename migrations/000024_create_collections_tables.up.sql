@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS collections (
+  id bigserial PRIMARY KEY,
+  organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  name text NOT NULL,
+  description text NOT NULL DEFAULT '',
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS collections_organization_id_idx ON collections (organization_id);
+
+-- A movie can only belong to one collection, and each position in a
+-- collection can only be taken by one movie.
+CREATE TABLE IF NOT EXISTS collections_movies (
+  collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+  movie_id bigint NOT NULL UNIQUE REFERENCES movies ON DELETE CASCADE,
+  position integer NOT NULL,
+  PRIMARY KEY (collection_id, position)
+);